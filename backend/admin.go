@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin guards operator endpoints with the ADMIN_TOKEN secret, sent
+// as "X-Admin-Token: <token>" or "Authorization: Bearer <token>". Without
+// ADMIN_TOKEN the admin endpoints are disabled.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv("ADMIN_TOKEN") == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled"})
+			return
+		}
+		if !isAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAdmin reports whether the request carries the admin token, for public
+// endpoints with admin-only options.
+func isAdmin(c *gin.Context) bool {
+	want := os.Getenv("ADMIN_TOKEN")
+	if want == "" {
+		return false
+	}
+	got := c.GetHeader("X-Admin-Token")
+	if got == "" {
+		got = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
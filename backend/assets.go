@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// The asset catalog lives in the database; assets.json only seeds it on
+// first start. Admins edit it through /api/b2a/assets, and the same rows
+// back the listing, the UCP manifest, TON orders and the B2A schema.
+
+const marketplaceName = "Artem PSX Assets"
+
+var (
+	assetIDPattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,63}$`)
+	assetCurrencies = []string{"TON", "STARS", "USD"}
+	assetStatuses   = []string{"available", "sold_out", "archived"}
+)
+
+// seedAssets imports assets.json into an empty catalog once.
+func seedAssets(data []byte) error {
+	var file struct {
+		Assets []store.Asset `json:"assets"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	for i := range file.Assets {
+		a := &file.Assets[i]
+		if err := validateAsset(a); err != nil {
+			return fmt.Errorf("%s: %w", a.ID, err)
+		}
+		if err := db.CreateAsset(a); err != nil && !errors.Is(err, store.ErrExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+func oneOf(v string, allowed []string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAsset checks an asset and fills the defaults: status
+// "available" and currency TON.
+func validateAsset(a *store.Asset) error {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Description = strings.TrimSpace(a.Description)
+	if a.Currency == "" {
+		a.Currency = "TON"
+	}
+	if a.Status == "" {
+		a.Status = "available"
+	}
+	a.Currency = strings.ToUpper(a.Currency)
+	switch {
+	case !assetIDPattern.MatchString(a.ID):
+		return errors.New("id must be 2-64 lowercase letters, digits or dashes")
+	case a.Name == "" || len(a.Name) > 200:
+		return errors.New("name is required (at most 200 characters)")
+	case len(a.Description) > 5000:
+		return errors.New("description is at most 5000 characters")
+	case a.Price < 0 || math.IsNaN(a.Price) || math.IsInf(a.Price, 0):
+		return errors.New("price must be a non-negative number")
+	case !oneOf(a.Currency, assetCurrencies):
+		return fmt.Errorf("currency must be one of %s", strings.Join(assetCurrencies, ", "))
+	case !oneOf(a.Status, assetStatuses):
+		return fmt.Errorf("status must be one of %s", strings.Join(assetStatuses, ", "))
+	}
+	return nil
+}
+
+// assetJSON is an asset as served, with the link to its Schema.org markup.
+type assetJSON struct {
+	store.Asset
+	SchemaURL string `json:"schema_url"`
+}
+
+func baseURL(c *gin.Context) string {
+	u := requestURL(c)
+	return strings.TrimSuffix(u, c.Request.URL.Path)
+}
+
+func publicAsset(c *gin.Context, a store.Asset) assetJSON {
+	return assetJSON{Asset: a, SchemaURL: baseURL(c) + "/api/b2a/schema?id=" + url.QueryEscape(a.ID)}
+}
+
+// assetFilter reads category, currency, status, min_price, max_price,
+// limit and offset from the query.
+func assetFilter(c *gin.Context) (store.AssetFilter, bool) {
+	f := store.AssetFilter{
+		Category: c.Query("category"),
+		Currency: strings.ToUpper(c.Query("currency")),
+		Status:   c.Query("status"),
+	}
+	for _, p := range []struct {
+		key string
+		dst **float64
+	}{{"min_price", &f.MinPrice}, {"max_price", &f.MaxPrice}} {
+		if v := c.Query(p.key); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.key + " must be a non-negative number"})
+				return f, false
+			}
+			*p.dst = &n
+		}
+	}
+	var err error
+	if f.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "20")); err != nil || f.Limit <= 0 || f.Limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return f, false
+	}
+	if f.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil || f.Offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative number"})
+		return f, false
+	}
+	return f, true
+}
+
+func handleGetAssets(c *gin.Context) {
+	f, ok := assetFilter(c)
+	if !ok {
+		return
+	}
+	assets, total, err := db.ListAssets(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := make([]assetJSON, 0, len(assets))
+	for _, a := range assets {
+		list = append(list, publicAsset(c, a))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"version":          "1.0",
+		"marketplace_name": marketplaceName,
+		"assets":           list,
+		"total":            total,
+		"limit":            f.Limit,
+		"offset":           f.Offset,
+	})
+}
+
+// findAsset loads an asset, answering 404 or 500 when it cannot.
+func findAsset(c *gin.Context, id string) (store.Asset, bool) {
+	a, err := db.GetAsset(id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return a, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return a, false
+	}
+	return a, true
+}
+
+func handleGetAsset(c *gin.Context) {
+	if a, ok := findAsset(c, c.Param("id")); ok {
+		c.JSON(http.StatusOK, publicAsset(c, a))
+	}
+}
+
+func handleCreateAsset(c *gin.Context) {
+	var a store.Asset
+	if err := c.ShouldBindJSON(&a); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset: " + err.Error()})
+		return
+	}
+	a.CreatedAt = time.Time{}
+	if err := validateAsset(&a); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := db.CreateAsset(&a)
+	if errors.Is(err, store.ErrExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Asset %q already exists", a.ID)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, publicAsset(c, a))
+}
+
+// handleUpdateAsset replaces an asset with the request body; the id comes
+// from the path.
+func handleUpdateAsset(c *gin.Context) {
+	var a store.Asset
+	if err := c.ShouldBindJSON(&a); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset: " + err.Error()})
+		return
+	}
+	a.ID = c.Param("id")
+	if err := validateAsset(&a); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := db.UpdateAsset(&a)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, publicAsset(c, a))
+}
+
+func handleDeleteAsset(c *gin.Context) {
+	err := db.DeleteAsset(c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// catalogItems lists the available assets for the UCP manifest.
+func catalogItems(c *gin.Context) ([]gin.H, error) {
+	assets, _, err := db.ListAssets(store.AssetFilter{Status: "available", Limit: 100})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]gin.H, 0, len(assets))
+	for _, a := range assets {
+		item := gin.H{
+			"id":       a.ID,
+			"name":     a.Name,
+			"category": a.Category,
+			"price":    a.Price,
+			"currency": a.Currency,
+			"schema":   publicAsset(c, a).SchemaURL,
+		}
+		if a.Currency == "TON" {
+			item["purchase"] = "/api/ton/orders"
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// schemaType maps an asset category to the "type" the B2A schema prompt
+// expects.
+func schemaType(category string) string {
+	c := strings.ToLower(category)
+	switch {
+	case strings.Contains(c, "3d"), strings.Contains(c, "model"), strings.Contains(c, "environment"):
+		return "3dmodel"
+	case strings.Contains(c, "software"), strings.Contains(c, "plugin"), strings.Contains(c, "script"):
+		return "software"
+	case strings.Contains(c, "service"):
+		return "service"
+	}
+	return "product"
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idea categories live in prompts/categories.json next to the templates they
+// use and are reloaded together with them.
+const categoriesFile = "categories.json"
+
+// ideaCategory configures how ideas of one category are generated.
+type ideaCategory struct {
+	ID      string            `json:"id"`
+	Title   map[string]string `json:"title,omitempty"` // locale -> title
+	Emoji   string            `json:"emoji,omitempty"`
+	Aliases []string          `json:"aliases,omitempty"`
+	// Prompt is the generator template, idea.generate by default.
+	Prompt string `json:"prompt,omitempty"`
+	// Critic enables the refining pass with CriticPrompt (idea.critic by default).
+	Critic       bool   `json:"critic"`
+	CriticPrompt string `json:"critic_prompt,omitempty"`
+	// CriticRounds, Rubric and MinScore tune the critique pipeline; unset
+	// values come from the file's "critic_defaults".
+	CriticRounds int          `json:"critic_rounds,omitempty"`
+	Rubric       []rubricItem `json:"rubric,omitempty"`
+	MinScore     float64      `json:"min_score,omitempty"`
+	// Model is a provider spec such as "groq" or "openai:gpt-4o-mini|groq".
+	// An X-LLM-Provider header on the request still wins.
+	Model string `json:"model,omitempty"`
+	// Locale forces the template locale instead of the caller's.
+	Locale string `json:"locale,omitempty"`
+	// Examples seed the generator until the category has liked ideas.
+	Examples []string `json:"examples,omitempty"`
+}
+
+// TitleFor returns the title in locale, else any title, else the id.
+func (c ideaCategory) TitleFor(locale string) string {
+	if t := c.Title[locale]; t != "" {
+		return t
+	}
+	for _, t := range c.Title {
+		return t
+	}
+	return c.ID
+}
+
+type categoryFile struct {
+	Default        string         `json:"default"`
+	CriticDefaults criticDefaults `json:"critic_defaults"`
+	// Fallback applies to categories that are not listed.
+	Fallback   ideaCategory   `json:"fallback"`
+	Categories []ideaCategory `json:"categories"`
+}
+
+type criticDefaults struct {
+	Rounds   int          `json:"rounds"`
+	Rubric   []rubricItem `json:"rubric"`
+	MinScore float64      `json:"min_score"`
+}
+
+type categoryRegistry struct {
+	categoryFile
+	byName map[string]*ideaCategory // lowercased ids and aliases
+}
+
+func loadCategories(fsys fs.FS, sets map[string]*promptSet) (*categoryRegistry, error) {
+	data, err := fs.ReadFile(fsys, categoriesFile)
+	if err != nil {
+		return nil, err
+	}
+	r := &categoryRegistry{byName: make(map[string]*ideaCategory)}
+	if err := json.Unmarshal(data, &r.categoryFile); err != nil {
+		return nil, fmt.Errorf("%s: %w", categoriesFile, err)
+	}
+
+	if r.CriticDefaults.Rounds <= 0 {
+		r.CriticDefaults.Rounds = 1
+	}
+	if err := r.Fallback.check(sets, r.CriticDefaults); err != nil {
+		return nil, fmt.Errorf("%s: fallback: %w", categoriesFile, err)
+	}
+	for i := range r.Categories {
+		c := &r.Categories[i]
+		if c.ID == "" {
+			return nil, fmt.Errorf("%s: category %d has no id", categoriesFile, i+1)
+		}
+		if err := c.check(sets, r.CriticDefaults); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", categoriesFile, c.ID, err)
+		}
+		for _, name := range append([]string{c.ID}, c.Aliases...) {
+			key := strings.ToLower(name)
+			if _, dup := r.byName[key]; dup {
+				return nil, fmt.Errorf("%s: %q is used by two categories", categoriesFile, name)
+			}
+			r.byName[key] = c
+		}
+	}
+	if r.Default != "" && r.byName[strings.ToLower(r.Default)] == nil {
+		return nil, fmt.Errorf("%s: unknown default category %q", categoriesFile, r.Default)
+	}
+	return r, nil
+}
+
+// check fills in defaults and verifies that templates and providers exist.
+func (c *ideaCategory) check(sets map[string]*promptSet, defaults criticDefaults) error {
+	if c.Prompt == "" {
+		c.Prompt = "idea.generate"
+	}
+	if c.Critic && c.CriticPrompt == "" {
+		c.CriticPrompt = "idea.critic"
+	}
+	if c.CriticRounds <= 0 {
+		c.CriticRounds = defaults.Rounds
+	}
+	if c.Rubric == nil {
+		c.Rubric = defaults.Rubric
+	}
+	if c.MinScore == 0 {
+		c.MinScore = defaults.MinScore
+	}
+	for _, item := range c.Rubric {
+		if item.Name == "" {
+			return errors.New("rubric item without a name")
+		}
+	}
+	for _, name := range []string{c.Prompt, c.CriticPrompt} {
+		if name != "" && sets[name] == nil {
+			return fmt.Errorf("unknown template %q", name)
+		}
+	}
+	if c.Model != "" {
+		for _, spec := range strings.Split(c.Model, "|") {
+			if _, _, err := resolveProvider(strings.TrimSpace(spec)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pipeline is the critique pipeline for the category; zero rounds when
+// the critic is off.
+func (c ideaCategory) pipeline() critiquePipeline {
+	if !c.Critic {
+		return critiquePipeline{}
+	}
+	return critiquePipeline{Critic: c.CriticPrompt, Rounds: c.CriticRounds, Rubric: c.Rubric, MinScore: c.MinScore}
+}
+
+// Lookup resolves an id or alias; an empty name is the default category.
+// Unknown names get the fallback settings under their own id and ok=false.
+func (r *categoryRegistry) Lookup(name string) (ideaCategory, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = r.Default
+	}
+	if c, ok := r.byName[strings.ToLower(name)]; ok {
+		return *c, true
+	}
+	c := r.Fallback
+	c.ID = name
+	return c, false
+}
+
+func (r *promptRegistry) Categories() *categoryRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.categories
+}
+
+// lookupCategory resolves name against the current category registry.
+func lookupCategory(name string) (ideaCategory, bool) {
+	return prompts.Categories().Lookup(name)
+}
+
+func handleIdeaCategories(c *gin.Context) {
+	reg := prompts.Categories()
+	locale := promptLocale(c)
+	if locale == "" {
+		locale = prompts.DefaultLocale()
+	}
+
+	type categoryInfo struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Emoji  string `json:"emoji,omitempty"`
+		Critic bool   `json:"critic"`
+	}
+	list := make([]categoryInfo, 0, len(reg.Categories))
+	for _, cat := range reg.Categories {
+		list = append(list, categoryInfo{ID: cat.ID, Title: cat.TitleFor(locale), Emoji: cat.Emoji, Critic: cat.Critic})
+	}
+	c.JSON(http.StatusOK, gin.H{"default": reg.Default, "locale": locale, "categories": list})
+}
@@ -0,0 +1,321 @@
+// Command fakebotapi is a local stand-in for the Telegram Bot API, enough to
+// test the Stars payment flow end to end without Telegram:
+//
+//	go run ./cmd/fakebotapi -webhook http://localhost:8080/api/telegram/webhook -secret s3cret
+//	TELEGRAM_API_URL=http://localhost:8081 TELEGRAM_WEBHOOK_SECRET=s3cret ./main
+//
+// It implements createInvoiceLink and answerPreCheckoutQuery for any bot
+// token. POST /fake/pay {"invoice": "<link or payload>", "user_id": 42}
+// plays the user paying: it sends a pre_checkout_query to the webhook, waits
+// for the answer and, if accepted, sends the successful_payment message.
+// GET /fake/invoices lists the invoices created so far.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type price struct {
+	Label  string `json:"label"`
+	Amount int64  `json:"amount"`
+}
+
+type invoice struct {
+	Slug        string  `json:"slug"`
+	Link        string  `json:"link"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Payload     string  `json:"payload"`
+	Currency    string  `json:"currency"`
+	Prices      []price `json:"prices"`
+	Status      string  `json:"status"` // open, paid, declined
+	ChargeID    string  `json:"charge_id,omitempty"`
+}
+
+func (inv *invoice) total() int64 {
+	var sum int64
+	for _, p := range inv.Prices {
+		sum += p.Amount
+	}
+	return sum
+}
+
+type answer struct {
+	OK           bool   `json:"ok"`
+	ErrorMessage string `json:"error_message"`
+}
+
+type server struct {
+	webhook string
+	secret  string
+
+	mu       sync.Mutex
+	invoices map[string]*invoice    // by slug
+	answers  map[string]chan answer // pre_checkout_query id -> answer
+	updateID int64
+}
+
+func main() {
+	addr := flag.String("addr", ":8081", "listen address")
+	webhook := flag.String("webhook", "http://localhost:8080/api/telegram/webhook", "bot webhook URL")
+	secret := flag.String("secret", "", "secret_token sent as X-Telegram-Bot-Api-Secret-Token")
+	flag.Parse()
+
+	s := &server{webhook: *webhook, secret: *secret, invoices: map[string]*invoice{}, answers: map[string]chan answer{}}
+	http.HandleFunc("/fake/pay", s.handlePay)
+	http.HandleFunc("/fake/invoices", s.handleInvoices)
+	http.HandleFunc("/", s.handleBotAPI)
+	log.Printf("Fake Bot API on %s, webhook %s", *addr, *webhook)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func botOK(w http.ResponseWriter, result interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "result": result})
+}
+
+func botError(w http.ResponseWriter, code int, description string) {
+	writeJSON(w, code, map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+// handleBotAPI serves /bot<token>/<method>.
+func (s *server) handleBotAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/bot")
+	token, method, found := strings.Cut(path, "/")
+	if !found || path == r.URL.Path || token == "" {
+		botError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	switch method {
+	case "getMe":
+		botOK(w, map[string]interface{}{"id": 1, "is_bot": true, "first_name": "Fake Ezhik", "username": "fake_ezhik_bot"})
+	case "createInvoiceLink":
+		s.createInvoiceLink(w, r)
+	case "answerPreCheckoutQuery":
+		s.answerPreCheckoutQuery(w, r)
+	default:
+		botError(w, http.StatusNotFound, "Not Found: method "+method+" is not faked")
+	}
+}
+
+func (s *server) createInvoiceLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		invoice
+		ProviderToken string `json:"provider_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		botError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	inv := req.invoice
+	switch {
+	case inv.Title == "" || inv.Description == "" || inv.Payload == "":
+		botError(w, http.StatusBadRequest, "Bad Request: title, description and payload are required")
+		return
+	case inv.Currency == "XTR" && req.ProviderToken != "":
+		botError(w, http.StatusBadRequest, "Bad Request: provider_token must be empty for Telegram Stars")
+		return
+	case inv.Currency == "XTR" && len(inv.Prices) != 1:
+		botError(w, http.StatusBadRequest, "Bad Request: Telegram Stars invoices must have exactly one price")
+		return
+	case inv.total() <= 0:
+		botError(w, http.StatusBadRequest, "Bad Request: invalid prices")
+		return
+	}
+	inv.Slug = randomHex(8)
+	inv.Link = "https://t.me/$" + inv.Slug
+	inv.Status = "open"
+	s.mu.Lock()
+	s.invoices[inv.Slug] = &inv
+	s.mu.Unlock()
+	log.Printf("Invoice %s: %d %s, payload %s", inv.Slug, inv.total(), inv.Currency, inv.Payload)
+	botOK(w, inv.Link)
+}
+
+func (s *server) answerPreCheckoutQuery(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"pre_checkout_query_id"`
+		answer
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		botError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	s.mu.Lock()
+	ch, ok := s.answers[req.ID]
+	delete(s.answers, req.ID)
+	s.mu.Unlock()
+	if !ok {
+		botError(w, http.StatusBadRequest, "Bad Request: query is too old and response timeout expired or query ID is invalid")
+		return
+	}
+	ch <- req.answer
+	botOK(w, true)
+}
+
+// findInvoice accepts a slug, an invoice link or an invoice payload.
+func (s *server) findInvoice(ref string) *invoice {
+	ref = strings.TrimPrefix(ref, "https://t.me/$")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inv, ok := s.invoices[ref]; ok {
+		return inv
+	}
+	for _, inv := range s.invoices {
+		if inv.Payload == ref {
+			return inv
+		}
+	}
+	return nil
+}
+
+func (s *server) handlePay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST only"})
+		return
+	}
+	var req struct {
+		Invoice string `json:"invoice"`
+		UserID  int64  `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Invoice == "" || req.UserID == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invoice and user_id are required"})
+		return
+	}
+	inv := s.findInvoice(req.Invoice)
+	if inv == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown invoice"})
+		return
+	}
+
+	status, detail, err := s.pay(inv, req.UserID)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	if status == "declined" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": status, "error": detail})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": status, "charge_id": detail})
+}
+
+// pay runs the checkout the way Telegram does: pre_checkout_query, a 10
+// second wait for the answer, then the successful_payment message. detail is
+// the charge id, or the error message of a declined checkout.
+func (s *server) pay(inv *invoice, userID int64) (status, detail string, err error) {
+	from := map[string]interface{}{"id": userID, "is_bot": false, "first_name": "Buyer"}
+	queryID := randomHex(8)
+	ch := make(chan answer, 1)
+	s.mu.Lock()
+	s.answers[queryID] = ch
+	s.mu.Unlock()
+
+	err = s.sendUpdate(map[string]interface{}{"pre_checkout_query": map[string]interface{}{
+		"id":              queryID,
+		"from":            from,
+		"currency":        inv.Currency,
+		"total_amount":    inv.total(),
+		"invoice_payload": inv.Payload,
+	}})
+	if err != nil {
+		return "", "", err
+	}
+	var a answer
+	select {
+	case a = <-ch:
+	case <-time.After(10 * time.Second):
+		s.mu.Lock()
+		delete(s.answers, queryID)
+		s.mu.Unlock()
+		return "", "", errors.New("no answerPreCheckoutQuery within 10s")
+	}
+	if !a.OK {
+		s.setStatus(inv, "declined", "")
+		return "declined", a.ErrorMessage, nil
+	}
+
+	chargeID := "stxfake" + randomHex(12)
+	err = s.sendUpdate(map[string]interface{}{"message": map[string]interface{}{
+		"message_id": time.Now().UnixNano(),
+		"date":       time.Now().Unix(),
+		"from":       from,
+		"chat":       map[string]interface{}{"id": userID, "type": "private"},
+		"successful_payment": map[string]interface{}{
+			"currency":                   inv.Currency,
+			"total_amount":               inv.total(),
+			"invoice_payload":            inv.Payload,
+			"telegram_payment_charge_id": chargeID,
+			"provider_payment_charge_id": "",
+		},
+	}})
+	if err != nil {
+		return "", "", err
+	}
+	s.setStatus(inv, "paid", chargeID)
+	return "paid", chargeID, nil
+}
+
+func (s *server) setStatus(inv *invoice, status, chargeID string) {
+	s.mu.Lock()
+	inv.Status, inv.ChargeID = status, chargeID
+	s.mu.Unlock()
+}
+
+func (s *server) sendUpdate(update map[string]interface{}) error {
+	s.mu.Lock()
+	s.updateID++
+	update["update_id"] = s.updateID
+	s.mu.Unlock()
+
+	body, _ := json.Marshal(update)
+	req, err := http.NewRequest(http.MethodPost, s.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", s.secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *server) handleInvoices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	list := make([]invoice, 0, len(s.invoices))
+	for _, inv := range s.invoices {
+		list = append(list, *inv)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,155 @@
+// Command faketonindexer is a local stand-in for toncenter, enough to test
+// TON asset purchases end to end without the blockchain:
+//
+//	go run ./cmd/faketonindexer
+//	TON_INDEXER_URL=http://localhost:8082 TON_POLL_INTERVAL=2s ./main
+//
+// It serves GET /api/v2/getTransactions for any address, paging with lt and
+// hash. POST /fake/transfer
+// {"to": "<address>", "from": "<address>", "ton": 10, "comment": "<memo>"}
+// plays a wallet sending TON ("nano" gives the amount in nanotons instead).
+// GET /fake/transactions lists every transfer so far.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type message struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Value       string `json:"value"`
+	Message     string `json:"message"`
+}
+
+type transactionID struct {
+	LT   string `json:"lt"`
+	Hash string `json:"hash"`
+}
+
+// transaction has the fields of a toncenter v2 raw.transaction the shop reads.
+type transaction struct {
+	Type          string        `json:"@type"`
+	Utime         int64         `json:"utime"`
+	TransactionID transactionID `json:"transaction_id"`
+	InMsg         message       `json:"in_msg"`
+	OutMsgs       []message     `json:"out_msgs"`
+}
+
+type server struct {
+	mu  sync.Mutex
+	txs []transaction // oldest first
+	lt  int64
+}
+
+func main() {
+	addr := flag.String("addr", ":8082", "listen address")
+	flag.Parse()
+
+	s := &server{lt: 1000}
+	http.HandleFunc("/api/v2/getTransactions", s.handleGetTransactions)
+	http.HandleFunc("/fake/transfer", s.handleTransfer)
+	http.HandleFunc("/fake/transactions", s.handleList)
+	log.Printf("Fake TON indexer on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleGetTransactions returns the latest transactions to address, newest
+// first, like toncenter. With lt and hash it pages back from that
+// transaction, which is included again.
+func (s *server) handleGetTransactions(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		reply(w, http.StatusUnprocessableEntity, map[string]interface{}{"ok": false, "error": "address is required", "code": 422})
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	lt, hash := r.URL.Query().Get("lt"), r.URL.Query().Get("hash")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := len(s.txs) - 1
+	if lt != "" {
+		start = -1
+		for i, tx := range s.txs {
+			if tx.TransactionID.LT == lt && tx.TransactionID.Hash == hash {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			reply(w, http.StatusNotFound, map[string]interface{}{"ok": false, "error": "transaction not found", "code": 404})
+			return
+		}
+	}
+	out := []transaction{}
+	for i := start; i >= 0 && len(out) < limit; i-- {
+		if s.txs[i].InMsg.Destination == address {
+			out = append(out, s.txs[i])
+		}
+	}
+	reply(w, http.StatusOK, map[string]interface{}{"ok": true, "result": out})
+}
+
+func (s *server) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		To      string  `json:"to"`
+		From    string  `json:"from"`
+		TON     float64 `json:"ton"`
+		Nano    int64   `json:"nano"`
+		Comment string  `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" {
+		reply(w, http.StatusBadRequest, map[string]string{"error": "to is required"})
+		return
+	}
+	if req.From == "" {
+		req.From = "EQfake-wallet"
+	}
+	if req.Nano == 0 {
+		req.Nano = int64(math.Round(req.TON * 1e9))
+	}
+	hash := make([]byte, 32)
+	rand.Read(hash)
+
+	s.mu.Lock()
+	s.lt++
+	tx := transaction{
+		Type:          "raw.transaction",
+		Utime:         time.Now().Unix(),
+		TransactionID: transactionID{LT: strconv.FormatInt(s.lt, 10), Hash: base64.StdEncoding.EncodeToString(hash)},
+		InMsg:         message{Source: req.From, Destination: req.To, Value: strconv.FormatInt(req.Nano, 10), Message: req.Comment},
+		OutMsgs:       []message{},
+	}
+	s.txs = append(s.txs, tx)
+	s.mu.Unlock()
+	log.Printf("Transfer %d nanoton %s -> %s %q", req.Nano, req.From, req.To, req.Comment)
+	reply(w, http.StatusOK, tx)
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply(w, http.StatusOK, s.txs)
+}
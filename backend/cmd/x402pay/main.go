@@ -0,0 +1,113 @@
+// Command x402pay calls an x402-priced endpoint and pays through the local
+// stand-in facilitator (X402_FACILITATOR=local):
+//
+//	go run ./cmd/x402pay -secret $X402_LOCAL_SECRET -from agent-wallet \
+//	    -url http://localhost:8080/api/supervisor/marketing -data '{"goal":"..."}'
+//
+// It sends the request, reads the 402 requirements, signs a payment with the
+// shared secret the way the local facilitator expects, retries with
+// X-PAYMENT and prints the response and the decoded X-PAYMENT-RESPONSE.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+type requirement struct {
+	Scheme            string            `json:"scheme"`
+	Network           string            `json:"network"`
+	MaxAmountRequired string            `json:"maxAmountRequired"`
+	PayTo             string            `json:"payTo"`
+	MaxTimeoutSeconds int               `json:"maxTimeoutSeconds"`
+	Asset             string            `json:"asset"`
+	Extra             map[string]string `json:"extra"`
+}
+
+type localPayment struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Amount      string `json:"amount"`
+	Asset       string `json:"asset"`
+	Nonce       string `json:"nonce"`
+	ValidBefore int64  `json:"validBefore"`
+	Signature   string `json:"signature"`
+}
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/api/supervisor/marketing", "endpoint to call")
+	data := flag.String("data", `{"goal":"Launch a coffee shop"}`, "JSON request body")
+	secret := flag.String("secret", os.Getenv("X402_LOCAL_SECRET"), "local facilitator secret")
+	from := flag.String("from", "x402pay", "payer address")
+	flag.Parse()
+
+	resp, body := post(*url, *data, "")
+	if resp.StatusCode != http.StatusPaymentRequired {
+		fmt.Printf("%s (no payment needed)\n%s\n", resp.Status, body)
+		return
+	}
+	var challenge struct {
+		Error   string        `json:"error"`
+		Accepts []requirement `json:"accepts"`
+	}
+	if err := json.Unmarshal(body, &challenge); err != nil || len(challenge.Accepts) == 0 {
+		log.Fatalf("402 without x402 requirements: %s", body)
+	}
+	req := challenge.Accepts[0]
+	fmt.Printf("402: %s\npaying %s %s to %s on %s\n", challenge.Error, req.MaxAmountRequired, req.Asset, req.PayTo, req.Network)
+
+	p := localPayment{
+		From:        *from,
+		To:          req.PayTo,
+		Amount:      req.MaxAmountRequired,
+		Asset:       req.Asset,
+		Nonce:       req.Extra["nonce"],
+		ValidBefore: time.Now().Add(time.Duration(req.MaxTimeoutSeconds) * time.Second).Unix(),
+	}
+	mac := hmac.New(sha256.New, []byte(*secret))
+	fmt.Fprintf(mac, "%s|%s|%s|%s|%s|%d", p.From, p.To, p.Amount, p.Asset, p.Nonce, p.ValidBefore)
+	p.Signature = hex.EncodeToString(mac.Sum(nil))
+
+	payload, _ := json.Marshal(p)
+	header, _ := json.Marshal(map[string]interface{}{
+		"x402Version": 1,
+		"scheme":      req.Scheme,
+		"network":     req.Network,
+		"payload":     json.RawMessage(payload),
+	})
+	resp, body = post(*url, *data, base64.StdEncoding.EncodeToString(header))
+	fmt.Printf("%s\n%s\n", resp.Status, body)
+	if receipt := resp.Header.Get("X-PAYMENT-RESPONSE"); receipt != "" {
+		decoded, _ := base64.StdEncoding.DecodeString(receipt)
+		fmt.Printf("X-PAYMENT-RESPONSE: %s\n", decoded)
+	}
+}
+
+func post(url, data, payment string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(data))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if payment != "" {
+		req.Header.Set("X-PAYMENT", payment)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rubricItem is one criterion the critic scores from 1 to 10.
+type rubricItem struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Weight      float64 `json:"weight,omitempty"` // 1 when unset
+}
+
+// critiquePipeline runs generate → critique → refine. Each round the critic
+// template reviews the current draft, scores it against Rubric and returns
+// notes plus a revised draft, which becomes the input of the next round.
+type critiquePipeline struct {
+	Critic string // template name; it receives .Draft, .Rubric, .Schema, .Round, .Rounds
+	Rounds int    // critique rounds, 0 skips the critic
+	Rubric []rubricItem
+	// MinScore stops early once the critic scores a draft at least this
+	// high; that draft is kept as is. 0 always runs every round.
+	MinScore float64
+	// Artifact is the schema of a draft; nil means plain text.
+	Artifact *jsonSchema
+}
+
+// critiqueRound records one critic pass.
+type critiqueRound struct {
+	Round   int                `json:"round"`
+	Draft   string             `json:"draft"`
+	Notes   []string           `json:"notes"`
+	Scores  map[string]float64 `json:"scores,omitempty"`
+	Score   float64            `json:"score,omitempty"`
+	Revised string             `json:"revised,omitempty"`
+}
+
+// critiqueResult is the pipeline output. Final is text, or compact JSON
+// when the pipeline has an Artifact schema.
+type critiqueResult struct {
+	Final  string          `json:"final"`
+	Draft  string          `json:"draft"`
+	Notes  []string        `json:"notes"`
+	Score  float64         `json:"score,omitempty"`
+	Rounds []critiqueRound `json:"rounds"`
+}
+
+type critiqueAnswer struct {
+	Notes   []string           `json:"notes"`
+	Scores  map[string]float64 `json:"scores"`
+	Revised json.RawMessage    `json:"revised"`
+}
+
+// Run calls generate for the first draft and then the critic rounds. data is
+// passed to the critic template next to the pipeline fields. If a critic
+// round fails the result holds the last good draft together with the error;
+// an error from generate is returned with an empty result.
+func (p critiquePipeline) Run(ctx context.Context, generate func(context.Context) (string, error), data gin.H) (critiqueResult, error) {
+	draft, err := generate(ctx)
+	if err != nil {
+		return critiqueResult{}, err
+	}
+	res := critiqueResult{Final: draft, Draft: draft, Notes: []string{}, Rounds: []critiqueRound{}}
+
+	schema := p.schema()
+	schemaJSON, _ := json.Marshal(schema)
+	for round := 1; round <= p.Rounds; round++ {
+		vars := gin.H{}
+		for k, v := range data {
+			vars[k] = v
+		}
+		vars["Draft"] = res.Final
+		vars["Rubric"] = p.Rubric
+		vars["Schema"] = string(schemaJSON)
+		vars["Round"] = round
+		vars["Rounds"] = p.Rounds
+
+		prompt, err := renderPrompt(ctx, p.Critic, vars)
+		if err != nil {
+			return res, err
+		}
+		var answer critiqueAnswer
+		if _, err := generateJSON(ctx, prompt.Request(), schema, &answer, 1); err != nil {
+			return res, fmt.Errorf("critic round %d: %w", round, err)
+		}
+		revised, err := p.decodeDraft(answer.Revised)
+		if err != nil {
+			return res, fmt.Errorf("critic round %d: %w", round, err)
+		}
+
+		r := critiqueRound{Round: round, Draft: res.Final, Notes: answer.Notes, Scores: answer.Scores, Score: p.score(answer.Scores)}
+		res.Notes, res.Score = answer.Notes, r.Score
+		if p.MinScore > 0 && r.Score >= p.MinScore {
+			res.Rounds = append(res.Rounds, r)
+			break
+		}
+		r.Revised = revised
+		res.Rounds = append(res.Rounds, r)
+		res.Final = revised
+	}
+	return res, nil
+}
+
+// schema is what the critic must answer with: notes, rubric scores and the
+// revised draft.
+func (p critiquePipeline) schema() *jsonSchema {
+	artifact := p.Artifact
+	if artifact == nil {
+		artifact = &jsonSchema{Type: "string"}
+	}
+	s := &jsonSchema{
+		Type: "object",
+		Properties: map[string]*jsonSchema{
+			"notes":   {Type: "array", Items: &jsonSchema{Type: "string"}},
+			"revised": artifact,
+		},
+		Required: []string{"notes", "revised"},
+	}
+	if len(p.Rubric) > 0 {
+		scores := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+		for _, item := range p.Rubric {
+			scores.Properties[item.Name] = &jsonSchema{Type: "number"}
+			scores.Required = append(scores.Required, item.Name)
+		}
+		s.Properties["scores"] = scores
+		s.Required = append(s.Required, "scores")
+	}
+	return s
+}
+
+func (p critiquePipeline) decodeDraft(raw json.RawMessage) (string, error) {
+	if p.Artifact != nil {
+		var b bytes.Buffer
+		if err := json.Compact(&b, raw); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("empty revision")
+	}
+	return text, nil
+}
+
+// score is the weighted mean of the rubric scores, each clamped to 1..10
+// and rounded to one decimal.
+func (p critiquePipeline) score(scores map[string]float64) float64 {
+	var sum, weights float64
+	for _, item := range p.Rubric {
+		w := item.Weight
+		if w <= 0 {
+			w = 1
+		}
+		sum += w * math.Max(1, math.Min(10, scores[item.Name]))
+		weights += w
+	}
+	if weights == 0 {
+		return 0
+	}
+	return math.Round(sum/weights*10) / 10
+}
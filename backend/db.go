@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"ezhik-ideas/store"
+)
+
+var db *store.Store
+
+// setupStore opens the SQLite database (DB_PATH, default ezhik.db) and
+// seeds the asset catalog from assets.json on first start.
+func setupStore() error {
+	var err error
+	db, err = store.Open(envOr("DB_PATH", "ezhik.db"))
+	if err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	importOnce("assets.json", seedAssets)
+	return nil
+}
+
+// importOnce loads a file into the database the first time it is found.
+// The file is left in place.
+func importOnce(name string, load func([]byte) error) {
+	key := "imported:" + name
+	if done, _ := db.Meta(key); done != "" {
+		return
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return
+	}
+	if err := load(data); err != nil {
+		log.Printf("Import %s: %v", name, err)
+		return
+	}
+	db.SetMeta(key, "1")
+	log.Printf("Imported %s into the database", name)
+}
+
+// isPremium reports whether userID has an active premium entitlement.
+func isPremium(userID string) bool {
+	ok, err := db.IsPremium(userID)
+	if err != nil {
+		log.Printf("Premium lookup error: %v", err)
+	}
+	return ok
+}
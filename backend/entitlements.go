@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Entitlements: a feature is unlocked by a subscription tier that includes
+// it, by a per-feature grant, or by credits that are spent one per call.
+// Tiers and grants may expire. requireFeature checks them in that order.
+
+// subscriptionTier is sold for Stars; Days 0 never expires. Price and Days
+// can be overridden with STARS_<ID>_PRICE and STARS_<ID>_DAYS.
+type subscriptionTier struct {
+	ID    string
+	Name  string
+	Price int64
+	Days  int
+}
+
+// feature is a paid capability. Price is Stars per call when sold as
+// credits, 0 means it comes with a tier only. X402Amount is the x402 price
+// per call in atomic units of X402_ASSET (nanotons for TON).
+type feature struct {
+	ID         string
+	Name       string
+	Tiers      []string
+	Price      int64
+	X402Amount string
+	Locked     string // 402 message
+}
+
+var subscriptionTiers = []subscriptionTier{
+	{ID: "pro", Name: "Ezhik Pro", Price: 25, Days: 30},
+	{ID: "premium", Name: "Ezhik Premium", Price: 50},
+}
+
+var features = []feature{
+	{ID: "pro-brainstorm", Name: "Pro Brainstorm", Tiers: []string{"pro", "premium"},
+		Locked: "Premium required. Buy Stars to unlock!"},
+	{ID: "outreach-drafter", Name: "Ezhik Outreach Drafter", Tiers: []string{"pro", "premium"}, Price: 10, X402Amount: "100000000",
+		Locked: "Premium required. Buy Stars to unlock the Marketing Specialist!"},
+	{ID: "startup-builder", Name: "Ezhik Startup Builder", Tiers: []string{"premium"}, Price: 50, X402Amount: "500000000",
+		Locked: "Premium required. Buy Stars to unlock the Supervisor!"},
+	{ID: "planner-critic-executor", Name: "Planner-Critic-Executor", Tiers: []string{"premium"},
+		Locked: "Premium required. Buy Stars to unlock high-stakes workflows!"},
+	{ID: "ralph", Name: "Ralph Mode", Tiers: []string{"premium"},
+		Locked: "Premium required. Buy Stars to unlock Ralph Mode (Autonomous Iteration)!"},
+}
+
+func lookupFeature(id string) (feature, bool) {
+	for _, f := range features {
+		if f.ID == id {
+			return f, true
+		}
+	}
+	return feature{}, false
+}
+
+func lookupTier(id string) (subscriptionTier, bool) {
+	for _, t := range subscriptionTiers {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return subscriptionTier{}, false
+}
+
+func (f feature) includedIn(tier string) bool {
+	for _, t := range f.Tiers {
+		if t == tier {
+			return true
+		}
+	}
+	return false
+}
+
+func setupEntitlements() {
+	for i := range subscriptionTiers {
+		t := &subscriptionTiers[i]
+		key := "STARS_" + strings.ToUpper(t.ID)
+		if v, err := strconv.ParseInt(envOr(key+"_PRICE", ""), 10, 64); err == nil && v > 0 {
+			t.Price = v
+		}
+		if v, err := strconv.Atoi(envOr(key+"_DAYS", "")); err == nil && v >= 0 {
+			t.Days = v
+		}
+	}
+}
+
+// featureAccess says how a user may use a feature; Via is "" when not at all.
+type featureAccess struct {
+	Feature   string     `json:"feature"`
+	Via       string     `json:"via"` // tier, grant, credits or x402
+	Tier      string     `json:"tier,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Credits   int64      `json:"credits,omitempty"`
+}
+
+// userEntitlements is everything the user holds.
+type userEntitlements struct {
+	Tier    *store.Premium
+	Grants  []store.FeatureGrant
+	Credits map[string]int64
+}
+
+func loadEntitlements(userID string) (userEntitlements, error) {
+	var e userEntitlements
+	var err error
+	if e.Tier, err = activeTier(db, userID); err != nil {
+		return e, err
+	}
+	if e.Grants, err = db.FeatureGrants(userID); err != nil {
+		return e, err
+	}
+	e.Credits, err = db.Credits(userID)
+	return e, err
+}
+
+func (e userEntitlements) access(f feature) featureAccess {
+	a := featureAccess{Feature: f.ID, Credits: e.Credits[f.ID]}
+	if e.Tier != nil && f.includedIn(e.Tier.Tier) {
+		a.Via, a.Tier, a.ExpiresAt = "tier", e.Tier.Tier, e.Tier.ExpiresAt
+		return a
+	}
+	for _, g := range e.Grants {
+		if g.Feature == f.ID && g.Active(time.Now()) {
+			a.Via, a.ExpiresAt = "grant", g.ExpiresAt
+			return a
+		}
+	}
+	if a.Credits > 0 {
+		a.Via = "credits"
+	}
+	return a
+}
+
+// entitlementKey holds the featureAccess requireFeature admitted with.
+const entitlementKey = "entitlement"
+
+// callerID is the Telegram user, or in TELEGRAM_AUTH_DEV mode the user_id
+// from the query or JSON body. The body is left for the handler to read.
+func callerID(c *gin.Context) string {
+	if v, ok := c.Get(telegramUserKey); ok {
+		return v.(telegramUser).UserID()
+	}
+	if !trustRequestUser() {
+		return ""
+	}
+	if id := c.Query("user_id"); id != "" {
+		return id
+	}
+	if c.Request.Body == nil {
+		return ""
+	}
+	data, _ := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	var body struct {
+		UserID string `json:"user_id"`
+	}
+	json.Unmarshal(data, &body)
+	return body.UserID
+}
+
+// requireFeature lets the request through when the caller is entitled to
+// the feature or pays for the call with x402, and answers 402 with the ways
+// to unlock it otherwise. A credit is spent up front and refunded if the
+// handler fails.
+func requireFeature(id string) gin.HandlerFunc {
+	f, ok := lookupFeature(id)
+	if !ok {
+		panic("unknown feature " + id)
+	}
+	return func(c *gin.Context) {
+		if x402Priced(f) && c.GetHeader(x402Header) != "" {
+			admitX402(c, f)
+			return
+		}
+		userID := callerID(c)
+		switch {
+		case userID == "" && x402Priced(f):
+			abortPaymentRequired(c, f, "")
+			return
+		case userID == "":
+			abortNoCaller(c)
+			return
+		}
+		ent, err := loadEntitlements(userID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		a := ent.access(f)
+		if a.Via == "credits" {
+			spent, err := db.UseCredit(userID, f.ID)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !spent {
+				a.Via = ""
+			} else {
+				a.Credits--
+			}
+		}
+		if a.Via == "" {
+			abortPaymentRequired(c, f, "")
+			return
+		}
+
+		c.Set(entitlementKey, a)
+		setCallUser(c, userID)
+		c.Next()
+
+		if a.Via == "credits" && requestFailed(c) {
+			if _, err := db.AddCredits(userID, f.ID, 1, "refund"); err != nil {
+				log.Printf("Credit refund error: %v", err)
+			}
+		}
+	}
+}
+
+// paymentRequired is the 402 body: the feature and every product that
+// unlocks it, to be bought via /api/stars/pay.
+func paymentRequired(f feature) gin.H {
+	var unlock []gin.H
+	for _, t := range subscriptionTiers {
+		if f.includedIn(t.ID) {
+			unlock = append(unlock, gin.H{"type": "tier", "product": t.ID, "name": t.Name,
+				"amount": t.Price, "currency": starsCurrency, "days": t.Days})
+		}
+	}
+	if f.Price > 0 {
+		unlock = append(unlock, gin.H{"type": "credits", "product": f.ID, "name": f.Name,
+			"amount": f.Price, "currency": starsCurrency, "credits": 1})
+	}
+	return gin.H{
+		"error":   f.Locked,
+		"code":    "payment_required",
+		"feature": f.ID,
+		"unlock":  unlock,
+		"pay":     "/api/stars/pay",
+	}
+}
+
+// errTierConflict is returned by grantTier when the grant would take away
+// part of the user's current tier.
+var errTierConflict = errors.New("tier conflict")
+
+// tierRank orders the tiers by what they include: later in
+// subscriptionTiers is higher, unknown tiers rank lowest.
+func tierRank(id string) int {
+	for i, t := range subscriptionTiers {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// tierStore is where tiers are read and written: the store, or the
+// transaction of a payment being fulfilled.
+type tierStore interface {
+	GetPremium(userID string) (store.Premium, error)
+	GrantTier(userID, tier, source string, expiresAt *time.Time) error
+}
+
+// activeTier returns the user's unexpired tier, nil if there is none.
+func activeTier(ts tierStore, userID string) (*store.Premium, error) {
+	p, err := ts.GetPremium(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil || !p.Active(time.Now()) {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// tierConflict says why granting tier for days (0 never expires) on top of
+// cur would cost the user something, "" for a new tier, an upgrade or a
+// renewal. A higher tier may replace a lower one unless the lower one
+// lasts forever and the higher one does not.
+func tierConflict(cur *store.Premium, tier string, days int) string {
+	switch {
+	case cur == nil:
+		return ""
+	case cur.Tier == tier && cur.ExpiresAt == nil:
+		return "You already have this tier forever"
+	case cur.Tier == tier:
+		return ""
+	case tierRank(cur.Tier) > tierRank(tier):
+		return "Your current tier already includes this one"
+	case cur.ExpiresAt == nil && days > 0:
+		return "Your current tier never expires; a time-limited upgrade would replace it"
+	}
+	return ""
+}
+
+// grantTier activates a tier for days (0 never expires) and counts the
+// conversion when the user had no active tier.
+func grantTier(userID, tier, source string, days int) error {
+	first, err := applyTier(db, userID, tier, source, days)
+	if err == nil && first {
+		recordStat("premium", "", source)
+	}
+	return err
+}
+
+// applyTier is grantTier without the stats, reporting whether the user had
+// no active tier. Buying the current tier again extends it. A grant that
+// tierConflict rejects returns errTierConflict and changes nothing.
+func applyTier(ts tierStore, userID, tier, source string, days int) (bool, error) {
+	cur, err := activeTier(ts, userID)
+	if err != nil {
+		return false, err
+	}
+	if reason := tierConflict(cur, tier, days); reason != "" {
+		return false, fmt.Errorf("%w: %s", errTierConflict, reason)
+	}
+
+	var expires *time.Time
+	if days > 0 {
+		from := time.Now()
+		if cur != nil && cur.Tier == tier {
+			from = *cur.ExpiresAt
+		}
+		t := from.AddDate(0, 0, days)
+		expires = &t
+	}
+	return cur == nil, ts.GrantTier(userID, tier, source, expires)
+}
+
+func handleEntitlements(c *gin.Context) {
+	userID := callerID(c)
+	if userID == "" {
+		abortNoCaller(c)
+		return
+	}
+	ent, err := loadEntitlements(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := make([]featureAccess, 0, len(features))
+	for _, f := range features {
+		list = append(list, ent.access(f))
+	}
+	resp := gin.H{"user_id": userID, "tier": nil, "features": list}
+	if ent.Tier != nil {
+		resp["tier"] = gin.H{"id": ent.Tier.Tier, "source": ent.Tier.Source, "expires_at": ent.Tier.ExpiresAt}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleGrantEntitlement lets an admin grant a tier, a feature or credits.
+func handleGrantEntitlement(c *gin.Context) {
+	var req struct {
+		UserID  string `json:"user_id" binding:"required"`
+		Tier    string `json:"tier"`
+		Feature string `json:"feature"`
+		Days    int    `json:"days"`
+		Credits int64  `json:"credits"`
+		Source  string `json:"source"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		return
+	}
+	if req.Source == "" {
+		req.Source = "admin"
+	}
+	var expires *time.Time
+	if req.Days > 0 {
+		t := time.Now().AddDate(0, 0, req.Days)
+		expires = &t
+	}
+
+	var err error
+	switch {
+	case req.Tier != "":
+		if _, ok := lookupTier(req.Tier); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown tier %q", req.Tier)})
+			return
+		}
+		err = grantTier(req.UserID, req.Tier, req.Source, req.Days)
+		if errors.Is(err, errTierConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+	case req.Feature != "":
+		if _, ok := lookupFeature(req.Feature); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown feature %q", req.Feature)})
+			return
+		}
+		if req.Credits != 0 {
+			_, err = db.AddCredits(req.UserID, req.Feature, req.Credits, req.Source)
+		} else {
+			err = db.GrantFeature(req.UserID, req.Feature, req.Source, expires)
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tier or feature is required"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "granted"})
+}
+
+// featurePrice is the Stars price of one call, for the UCP manifest.
+func featurePrice(id string) int64 {
+	f, _ := lookupFeature(id)
+	return f.Price
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"ezhik-ideas/store"
+)
+
+func TestGrantTier(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name      string
+		cur       string // tier granted first, "" for none
+		curDays   int
+		tier      string
+		days      int
+		wantErr   bool
+		wantTier  string
+		wantUntil time.Duration // 0 never expires
+	}{
+		{name: "new", tier: "pro", days: 30, wantTier: "pro", wantUntil: 30 * day},
+		{name: "upgrade", cur: "pro", curDays: 30, tier: "premium", wantTier: "premium"},
+		{name: "renewal", cur: "pro", curDays: 30, tier: "pro", days: 30, wantTier: "pro", wantUntil: 60 * day},
+		{name: "downgrade from lifetime", cur: "premium", tier: "pro", days: 30, wantErr: true, wantTier: "premium"},
+		{name: "downgrade from timed", cur: "premium", curDays: 30, tier: "pro", days: 30, wantErr: true,
+			wantTier: "premium", wantUntil: 30 * day},
+		{name: "lifetime again", cur: "premium", tier: "premium", wantErr: true, wantTier: "premium"},
+		{name: "timed upgrade over lifetime", cur: "pro", tier: "premium", days: 30, wantErr: true, wantTier: "pro"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDB(t)
+			if tt.cur != "" {
+				if err := grantTier("42", tt.cur, "test", tt.curDays); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := grantTier("42", tt.tier, "test", tt.days)
+			if tt.wantErr != errors.Is(err, errTierConflict) || (!tt.wantErr && err != nil) {
+				t.Fatalf("grantTier(%s) = %v, want conflict %v", tt.tier, err, tt.wantErr)
+			}
+			p, err := db.GetPremium("42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Tier != tt.wantTier {
+				t.Errorf("tier = %s, want %s", p.Tier, tt.wantTier)
+			}
+			switch {
+			case tt.wantUntil == 0 && p.ExpiresAt != nil:
+				t.Errorf("expires at %v, want never", p.ExpiresAt)
+			case tt.wantUntil != 0 && p.ExpiresAt == nil:
+				t.Errorf("never expires, want in %v", tt.wantUntil)
+			case tt.wantUntil != 0 && absDuration(time.Until(*p.ExpiresAt)-tt.wantUntil) > time.Minute:
+				t.Errorf("expires in %v, want %v", time.Until(*p.ExpiresAt), tt.wantUntil)
+			}
+		})
+	}
+}
+
+func TestGrantTierKeepsPremiumFeatures(t *testing.T) {
+	useTestDB(t)
+	if err := grantTier("42", "premium", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := grantTier("42", "pro", "stars", 30); !errors.Is(err, errTierConflict) {
+		t.Fatalf("pro over lifetime premium: %v, want conflict", err)
+	}
+	ent, err := loadEntitlements("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"startup-builder", "planner-critic-executor", "ralph"} {
+		f, _ := lookupFeature(id)
+		if a := ent.access(f); a.Via != "tier" {
+			t.Errorf("%s: access via %q, want tier", id, a.Via)
+		}
+	}
+}
+
+func TestPreCheckoutDeclinesDowngrade(t *testing.T) {
+	useTestDB(t)
+	setupStars()
+	if err := grantTier("42", "premium", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	p := store.Payment{Payload: "stars_test", UserID: "42", Product: "pro", Currency: starsCurrency,
+		Amount: starsProducts["pro"].Price}
+	if err := db.CreatePayment(&p); err != nil {
+		t.Fatal(err)
+	}
+	q := &preCheckoutQuery{ID: "q1", From: botUser{ID: 42}, Currency: p.Currency, TotalAmount: p.Amount,
+		InvoicePayload: p.Payload}
+	if reason := checkPreCheckout(q); reason == "" {
+		t.Error("pre-checkout accepted pro over lifetime premium")
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func TestRequestUserNeedsDevMode(t *testing.T) {
+	r, _ := newTestServer(t)
+	if err := grantTier("42", "premium", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	telegramAuthDev = false
+	t.Cleanup(func() { telegramAuthDev = true })
+
+	if w := do(t, r, http.MethodGet, "/api/entitlements?user_id=42", nil, nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("entitlements: %d %s, want 503", w.Code, w.Body)
+	}
+	w := do(t, r, http.MethodPost, "/api/supervisor/startup", map[string]string{"user_id": "42", "goal": "A hedgehog cafe"}, nil)
+	if w.Code == http.StatusOK {
+		t.Errorf("startup builder trusted the body user_id: %s", w.Body)
+	}
+
+	telegramAuthDev = true
+	if w := do(t, r, http.MethodGet, "/api/entitlements?user_id=42", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("entitlements in dev mode: %d %s, want 200", w.Code, w.Body)
+	}
+}
+
+func TestCreditRefundedOnFailure(t *testing.T) {
+	r, mock := newTestServer(t)
+	if _, err := db.AddCredits("42", "pro-brainstorm", 2, "test"); err != nil {
+		t.Fatal(err)
+	}
+	mock.Script("").Error = string(ErrKindAuth)
+
+	body := map[string]string{"user_id": "42", "prompt": "A hedgehog cafe"}
+	for _, path := range []string{"/api/pro-brainstorm", "/api/pro-brainstorm?stream=1"} {
+		w := do(t, r, http.MethodPost, path, body, nil)
+		credits, err := db.Credits("42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credits["pro-brainstorm"] != 2 {
+			t.Errorf("%s: %d %s left %d credits, want 2", path, w.Code, w.Body, credits["pro-brainstorm"])
+		}
+	}
+}
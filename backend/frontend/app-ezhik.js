@@ -10,6 +10,11 @@ if (Telegram) {
 }
 
 let currentIdea = '';
+let currentIdeaId = null;
+let currentCategory = '';
+const userId = Telegram && Telegram.initDataUnsafe && Telegram.initDataUnsafe.user ? String(Telegram.initDataUnsafe.user.id) : '';
+// The server takes the user from the signed initData, not from user_id
+const authHeaders = Telegram && Telegram.initData ? { 'X-Telegram-Init-Data': Telegram.initData } : {};
 let stats = { count: 0 };
 let history = [];
 
@@ -65,11 +70,13 @@ async function generateIdea() {
   actions.classList.add('hidden');
 
   try {
-    const response = await fetch(`${API_URL}/api/idea?category=${encodeURIComponent(category)}`);
+    const response = await fetch(`${API_URL}/api/idea?category=${encodeURIComponent(category)}`, { headers: authHeaders });
     if (!response.ok) throw new Error('API status: ' + response.status);
     
     const data = await response.json();
     currentIdea = data.idea;
+    currentIdeaId = data.id || null;
+    currentCategory = category;
     
     // Update UI
     ideaText.textContent = currentIdea;
@@ -152,8 +159,8 @@ async function sendFeedback(type) {
   try {
     await fetch(`${API_URL}/api/feedback`, {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({ idea: currentIdea, feedback: type })
+      headers: { 'Content-Type': 'application/json', ...authHeaders },
+      body: JSON.stringify({ idea_id: currentIdeaId, idea: currentIdea, category: currentCategory, user_id: userId, feedback: type })
     });
     
     const oldText = btn.textContent;
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var processStart = time.Now()
+
+const (
+	healthOK   = "ok"
+	healthFail = "fail"
+	// healthUnknown is reported for providers that cannot be probed.
+	healthUnknown = "unknown"
+)
+
+// healthCheck is the result of one probe. A failing critical check makes
+// the service unhealthy, any other failure only degraded.
+type healthCheck struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	LatencyMS int64     `json:"latency_ms"`
+	Detail    string    `json:"detail,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// healthMonitor runs the probes and caches the results for a while so
+// diagnostics and /metrics scrapes do not hammer the providers.
+type healthMonitor struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	timeout time.Duration
+	last    []healthCheck
+	at      time.Time
+}
+
+type healthProbe struct {
+	critical bool
+	run      func(context.Context) (detail string, err error)
+}
+
+var health = &healthMonitor{ttl: 30 * time.Second, timeout: 5 * time.Second}
+
+// Run returns cached results unless they are older than the TTL or force
+// is set, in which case all probes run concurrently.
+func (h *healthMonitor) Run(ctx context.Context, force bool) []healthCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !force && h.last != nil && time.Since(h.at) < h.ttl {
+		return h.last
+	}
+
+	probes := map[string]healthProbe{
+		"database": {critical: true, run: probeDatabase},
+		"storage":  {critical: true, run: probeStorage},
+		"yt-dlp":   {run: probeYtDlp},
+	}
+	for name, p := range llmProviders {
+		p := p
+		probes["llm:"+name] = healthProbe{run: func(ctx context.Context) (string, error) { return probeProvider(ctx, p) }}
+	}
+
+	results := make([]healthCheck, 0, len(probes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, p := range probes {
+		name, p := name, p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Results are shared, so a caller hanging up must not fail them
+			pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+			defer cancel()
+			start := time.Now()
+			detail, err := p.run(pctx)
+			check := healthCheck{Name: name, Status: healthOK, Critical: p.critical, Detail: detail,
+				LatencyMS: time.Since(start).Milliseconds(), CheckedAt: time.Now().UTC()}
+			if err == errNoProbe {
+				check.Status = healthUnknown
+			} else if err != nil {
+				check.Status, check.Error = healthFail, err.Error()
+			}
+			mu.Lock()
+			results = append(results, check)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	h.last, h.at = results, time.Now()
+	return results
+}
+
+// overallStatus is unhealthy when a critical check or every LLM provider
+// fails, degraded when anything else fails.
+func overallStatus(checks []healthCheck) string {
+	status := "healthy"
+	llmTotal, llmDown := 0, 0
+	for _, c := range checks {
+		if strings.HasPrefix(c.Name, "llm:") {
+			llmTotal++
+			if c.Status == healthFail {
+				llmDown++
+			}
+		}
+		if c.Status != healthFail {
+			continue
+		}
+		if c.Critical {
+			return "unhealthy"
+		}
+		status = "degraded"
+	}
+	if llmTotal > 0 && llmDown == llmTotal {
+		return "unhealthy"
+	}
+	return status
+}
+
+var errNoProbe = errors.New("no probe available")
+
+func probeDatabase(ctx context.Context) (string, error) {
+	if err := db.Ping(); err != nil {
+		return "", err
+	}
+	v, err := db.SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("schema v%d", v), nil
+}
+
+// probeStorage checks that uploads and generated emails can be written.
+func probeStorage(ctx context.Context) (string, error) {
+	f, err := os.CreateTemp(emailStorage, ".healthcheck-*")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	_, err = f.WriteString("ok")
+	f.Close()
+	os.Remove(name)
+	return emailStorage, err
+}
+
+func probeYtDlp(ctx context.Context) (string, error) {
+	path, err := exec.LookPath("yt-dlp")
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return path, err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func probeProvider(ctx context.Context, p Provider) (string, error) {
+	pinger, ok := p.(Pinger)
+	if !ok {
+		return "", errNoProbe
+	}
+	return p.DefaultModel(), pinger.Ping(ctx)
+}
+
+// handleDiagnostics serves the cached checks; ?refresh=1 probes everything
+// again, which costs provider calls, so it takes the admin token.
+func handleDiagnostics(c *gin.Context) {
+	refresh := c.Query("refresh") == "1"
+	if refresh && !isAdmin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh=1 requires the admin token"})
+		return
+	}
+	checks := health.Run(c.Request.Context(), refresh)
+	status := overallStatus(checks)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	code := http.StatusOK
+	if status == "unhealthy" {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status":     status,
+		"version":    "v3.1.0",
+		"started_at": processStart.UTC(),
+		"uptime":     time.Since(processStart).Round(time.Second).String(),
+		"checks":     checks,
+		"memory": gin.H{
+			"alloc_bytes":  mem.Alloc,
+			"sys_bytes":    mem.Sys,
+			"heap_objects": mem.HeapObjects,
+			"num_gc":       mem.NumGC,
+			"goroutines":   runtime.NumGoroutine(),
+		},
+		"llm_cache": responseCache.Stats(),
+	})
+}
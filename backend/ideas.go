@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ideaFewShot is how many liked and disliked ideas of the same category
+	// are shown to the generator as examples (IDEA_FEWSHOT_EXAMPLES, 0 disables).
+	ideaFewShot = 3
+	// ideaDupThreshold is the shingle similarity above which a new draft
+	// counts as a repeat of a stored idea (IDEA_DUP_THRESHOLD, 0 disables).
+	ideaDupThreshold = 0.5
+	// ideaDupRetries is how many times a duplicate draft is regenerated
+	// before it is accepted anyway (IDEA_DUP_RETRIES).
+	ideaDupRetries = 2
+)
+
+// ideaDupWindow is how many recent ideas of the category are compared.
+const ideaDupWindow = 100
+
+func setupIdeas() {
+	if n, err := strconv.Atoi(os.Getenv("IDEA_FEWSHOT_EXAMPLES")); err == nil {
+		ideaFewShot = n
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("IDEA_DUP_THRESHOLD"), 64); err == nil {
+		ideaDupThreshold = f
+	}
+	if n, err := strconv.Atoi(os.Getenv("IDEA_DUP_RETRIES")); err == nil && n >= 0 {
+		ideaDupRetries = n
+	}
+	log.Printf("Idea search: %s", db.SearchMode())
+}
+
+// ideaExamples returns the texts of the best and worst rated ideas.
+func ideaExamples(category string) (liked, disliked []string) {
+	if ideaFewShot <= 0 {
+		return nil, nil
+	}
+	best, err := db.RatedIdeas(category, true, ideaFewShot)
+	if err != nil {
+		log.Printf("Idea examples error: %v", err)
+		return nil, nil
+	}
+	worst, err := db.RatedIdeas(category, false, ideaFewShot)
+	if err != nil {
+		log.Printf("Idea examples error: %v", err)
+		return nil, nil
+	}
+	for _, i := range best {
+		liked = append(liked, i.Text)
+	}
+	for _, i := range worst {
+		disliked = append(disliked, i.Text)
+	}
+	return liked, disliked
+}
+
+// generateIdea drafts an idea with the category's template, regenerating
+// drafts that repeat a recent idea of the category, and runs the category's
+// critique pipeline on it. A failed critic pass keeps the last good draft.
+// Ideas never come from the response cache: a cached draft would repeat
+// itself on every regeneration.
+func generateIdea(ctx context.Context, cat ideaCategory) (critiqueResult, error) {
+	ctx = withoutCache(ctx)
+	if cat.Locale != "" {
+		ctx = withLocale(ctx, cat.Locale)
+	}
+	liked, disliked := ideaExamples(cat.ID)
+	if len(liked) == 0 {
+		liked = cat.Examples
+	}
+	recent := recentIdeas(cat.ID)
+	title := cat.TitleFor(scopeFromContext(ctx).Locale)
+
+	draft := func(ctx context.Context) (string, error) {
+		var rawIdea string
+		var avoid []string
+		for attempt := 0; ; attempt++ {
+			var err error
+			rawIdea, err = callPrompt(ctx, cat.Prompt, gin.H{
+				"Category": title, "Liked": liked, "Disliked": disliked, "Avoid": avoid,
+			})
+			if err != nil {
+				return "", err
+			}
+			dup, score := findDuplicateIdea(rawIdea, recent)
+			if dup == nil {
+				return rawIdea, nil
+			}
+			if attempt >= ideaDupRetries {
+				log.Printf("Idea draft still %.2f similar to idea %d after %d retries, keeping it", score, dup.ID, attempt)
+				return rawIdea, nil
+			}
+			log.Printf("Idea draft %.2f similar to idea %d, regenerating", score, dup.ID)
+			avoid = append(avoid, dup.Text)
+		}
+	}
+
+	res, err := cat.pipeline().Run(ctx, draft, gin.H{"Category": title})
+	if err != nil && res.Draft != "" {
+		log.Printf("Idea critic error: %v", err)
+		err = nil
+	}
+	return res, err
+}
+
+func recentIdeas(category string) []store.Idea {
+	if ideaDupThreshold <= 0 {
+		return nil
+	}
+	ideas, _, err := db.ListIdeas(store.IdeaFilter{Category: category, Limit: ideaDupWindow})
+	if err != nil {
+		log.Printf("Recent ideas error: %v", err)
+	}
+	return ideas
+}
+
+// findDuplicateIdea returns the most similar stored idea if its draft or
+// refined text is at least ideaDupThreshold similar to text.
+func findDuplicateIdea(text string, ideas []store.Idea) (*store.Idea, float64) {
+	if ideaDupThreshold <= 0 {
+		return nil, 0
+	}
+	sh := shingles(text)
+	var best *store.Idea
+	var bestScore float64
+	for i := range ideas {
+		for _, other := range []string{ideas[i].Raw, ideas[i].Text} {
+			if other == "" {
+				continue
+			}
+			if score := jaccard(sh, shingles(other)); score > bestScore {
+				best, bestScore = &ideas[i], score
+			}
+		}
+	}
+	if bestScore < ideaDupThreshold {
+		return nil, bestScore
+	}
+	return best, bestScore
+}
+
+// shingleSize is the length of the character n-grams compared. Character
+// shingles are used instead of words so Russian word endings still match.
+const shingleSize = 4
+
+// shingles returns the set of character n-grams of text, lowercased with
+// punctuation dropped and whitespace collapsed.
+func shingles(text string) map[string]struct{} {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	runes := []rune(strings.Join(words, " "))
+	set := make(map[string]struct{})
+	if len(runes) < shingleSize {
+		if len(runes) > 0 {
+			set[string(runes)] = struct{}{}
+		}
+		return set
+	}
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		set[string(runes[i:i+shingleSize])] = struct{}{}
+	}
+	return set
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	common := 0
+	for s := range a {
+		if _, ok := b[s]; ok {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// ideaFilter reads category, limit and offset from the query.
+func ideaFilter(c *gin.Context) (store.IdeaFilter, bool) {
+	f := store.IdeaFilter{Category: c.Query("category")}
+	var err error
+	if f.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "20")); err != nil || f.Limit <= 0 || f.Limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return f, false
+	}
+	if f.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0")); err != nil || f.Offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative number"})
+		return f, false
+	}
+	return f, true
+}
+
+// handleIdeaHistory lists the caller's own ideas.
+func handleIdeaHistory(c *gin.Context) {
+	userID := callerID(c)
+	if userID == "" {
+		abortNoCaller(c)
+		return
+	}
+	f, ok := ideaFilter(c)
+	if !ok {
+		return
+	}
+	f.UserID = userID
+	ideas, total, err := db.ListIdeas(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ideas": ideas, "total": total, "limit": f.Limit, "offset": f.Offset})
+}
+
+func handleSearchIdeas(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	f, ok := ideaFilter(c)
+	if !ok {
+		return
+	}
+	ideas, err := db.SearchIdeas(query, f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"query": query, "mode": db.SearchMode(), "ideas": ideas, "limit": f.Limit, "offset": f.Offset})
+}
+
+func handleGetIdea(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid idea id"})
+		return
+	}
+	idea, err := db.GetIdea(id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Idea not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, idea)
+}
+
+func handleTopIdeas(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	category := c.Query("category")
+	ideas, err := db.RatedIdeas(category, true, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"category": category, "ideas": ideas})
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChatMessage is a single turn of a conversation sent to a provider.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatRequest is the provider-neutral form of a completion call.
+type ChatRequest struct {
+	Model    string
+	System   string
+	Messages []ChatMessage
+	// JSONMode asks the provider for a single JSON object where supported.
+	JSONMode bool
+	// PromptID names the template variant the request was rendered from.
+	PromptID string
+}
+
+// Usage is the token accounting reported by a provider for one call.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Provider is an LLM backend able to answer a chat completion.
+type Provider interface {
+	Name() string
+	DefaultModel() string
+	Complete(ctx context.Context, req ChatRequest) (string, Usage, error)
+	// Stream behaves like Complete but calls onDelta for every text chunk
+	// as it arrives. The full text is returned at the end.
+	Stream(ctx context.Context, req ChatRequest, onDelta func(string)) (string, Usage, error)
+}
+
+// Pinger is implemented by providers that can check they are reachable and
+// the credentials work without spending tokens.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+var (
+	llmProviders       = make(map[string]Provider)
+	llmDefaultProvider string
+	llmRouteProviders  = make(map[string]string)
+	// llmAllowedOverrides are the specs anyone may pick with X-LLM-Provider
+	// (LLM_ALLOWED_OVERRIDES); a bare provider name allows all its models.
+	// Admins may pick any.
+	llmAllowedOverrides = make(map[string]bool)
+	llmHTTPClient       = &http.Client{}
+)
+
+// setupLLM registers the configured providers and loads the routing,
+// retry, pricing and cache settings from the environment.
+func setupLLM() error {
+	if key := os.Getenv("GROQ_API_KEY"); key != "" {
+		registerProvider(newGroqProvider(key, os.Getenv("GROQ_MODEL")))
+	}
+	if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+		registerProvider(newOpenAIProvider("openai", envOr("OPENAI_BASE_URL", "https://api.openai.com/v1"), key, envOr("OPENAI_MODEL", "gpt-4o-mini")))
+	}
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		registerProvider(newAnthropicProvider(key, os.Getenv("ANTHROPIC_MODEL")))
+	}
+
+	llmDefaultProvider = envOr("LLM_PROVIDER", "groq")
+	if llmDefaultProvider == "mock" || os.Getenv("MOCK_LLM_ENABLED") == "1" {
+		mock, err := newMockProviderFromEnv()
+		if err != nil {
+			return err
+		}
+		registerProvider(mock)
+	}
+	if _, ok := llmProviders[llmDefaultProvider]; !ok {
+		return fmt.Errorf("LLM provider %q is not configured (set its API key in environment or .env file, or use LLM_PROVIDER=mock offline)", llmDefaultProvider)
+	}
+
+	// LLM_ROUTES=/api/code=openai,/api/supervisor/startup=anthropic:claude-3-5-haiku-latest
+	for _, pair := range splitList(os.Getenv("LLM_ROUTES")) {
+		route, spec, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Printf("LLM_ROUTES: ignoring malformed entry %q", pair)
+			continue
+		}
+		llmRouteProviders[strings.TrimSpace(route)] = strings.TrimSpace(spec)
+	}
+	for _, spec := range splitList(os.Getenv("LLM_ALLOWED_OVERRIDES")) {
+		llmAllowedOverrides[strings.TrimSpace(spec)] = true
+	}
+
+	loadRetryConfig()
+	loadPrices()
+	setupResponseCache()
+	return nil
+}
+
+func registerProvider(p Provider) {
+	llmProviders[p.Name()] = p
+}
+
+type llmSpecKey struct{}
+
+// llmRouting picks the provider for the request: the X-LLM-Provider header
+// wins over the per-route configuration, which wins over LLM_PROVIDER.
+// A spec may list a fallback chain: "groq|openai:gpt-4o-mini". The header
+// is refused unless the caller is an admin or every spec in it is allowed.
+func llmRouting() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := &callScope{
+			Route:       c.FullPath(),
+			Locale:      promptLocale(c),
+			CacheBypass: c.GetHeader("X-Cache-Bypass") == "1" || c.GetHeader("Cache-Control") == "no-cache",
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), callScopeKey{}, scope))
+
+		spec := c.GetHeader("X-LLM-Provider")
+		if spec != "" && !overrideAllowed(c, spec) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "X-LLM-Provider " + spec + " is not allowed", "code": "provider_not_allowed"})
+			return
+		}
+		if spec == "" {
+			spec = llmRouteProviders[c.FullPath()]
+		}
+		if spec != "" {
+			for _, s := range strings.Split(spec, "|") {
+				if _, _, err := resolveProvider(strings.TrimSpace(s)); err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "unknown_provider"})
+					return
+				}
+			}
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), llmSpecKey{}, spec))
+		}
+		c.Next()
+	}
+}
+
+func overrideAllowed(c *gin.Context, spec string) bool {
+	if isAdmin(c) {
+		return true
+	}
+	for _, s := range strings.Split(spec, "|") {
+		s = strings.TrimSpace(s)
+		name, _, _ := strings.Cut(s, ":")
+		if !llmAllowedOverrides[s] && !llmAllowedOverrides[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveProvider parses a "provider[:model]" spec.
+func resolveProvider(spec string) (Provider, string, error) {
+	name, model, _ := strings.Cut(spec, ":")
+	if name == "" {
+		name = llmDefaultProvider
+	}
+	p, ok := llmProviders[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown LLM provider %q", name)
+	}
+	if model == "" {
+		model = p.DefaultModel()
+	}
+	return p, model, nil
+}
+
+// callLLM sends a single-turn prompt to the provider selected for ctx.
+// Failures are returned as *LLMError.
+func callLLM(ctx context.Context, prompt, systemPrompt string) (string, error) {
+	comp, err := completeLLM(ctx, ChatRequest{
+		System:   systemPrompt,
+		Messages: []ChatMessage{{Role: "user", Content: prompt}},
+	})
+	return comp.Text, err
+}
+
+// completeLLM runs req against the provider chain selected for ctx, with
+// retries and fallbacks. Answers for cacheable routes are served from and
+// stored in the response cache.
+func completeLLM(ctx context.Context, req ChatRequest) (Completion, error) {
+	read, write := cacheMode(ctx)
+	if read {
+		if candidates, err := llmCandidates(ctx); err == nil {
+			for _, cand := range candidates {
+				r := req
+				r.Model = cand.model
+				if comp, ok := responseCache.Get(cacheKey(cand.provider.Name(), r)); ok {
+					comp.Cached = true
+					comp.Attempts = 0
+					return comp, nil
+				}
+			}
+		}
+	}
+
+	comp, err := runWithFallbacks(ctx, req, func(p Provider, r ChatRequest) (string, Usage, error) {
+		return p.Complete(ctx, r)
+	}, func() bool { return true })
+	if err == nil && write {
+		r := req
+		r.Model = comp.Model
+		responseCache.Put(cacheKey(comp.Provider, r), comp)
+	}
+	return comp, err
+}
+
+// streamChat is the streaming counterpart of completeLLM. Once a token has
+// been passed to onDelta the call is no longer retried or failed over.
+func streamChat(ctx context.Context, req ChatRequest, onDelta func(string)) (Completion, error) {
+	streamed := false
+	return runWithFallbacks(ctx, req, func(p Provider, r ChatRequest) (string, Usage, error) {
+		return p.Stream(ctx, r, func(delta string) {
+			streamed = true
+			onDelta(delta)
+		})
+	}, func() bool { return !streamed })
+}
+
+// ============ OPENAI-COMPATIBLE (GROQ, OPENAI, ...) ============
+
+type openAIProvider struct {
+	name    string
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+func newOpenAIProvider(name, baseURL, apiKey, model string) *openAIProvider {
+	return &openAIProvider{name: name, baseURL: strings.TrimRight(baseURL, "/"), apiKey: apiKey, model: model}
+}
+
+func newGroqProvider(apiKey, model string) *openAIProvider {
+	if model == "" {
+		model = "llama-3.3-70b-versatile"
+	}
+	return newOpenAIProvider("groq", envOr("GROQ_BASE_URL", "https://api.groq.com/openai/v1"), apiKey, model)
+}
+
+// Ping lists the models, which needs a valid key but no tokens.
+func (p *openAIProvider) Ping(ctx context.Context) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	return pingRequest(p.name, httpReq)
+}
+
+func pingRequest(provider string, httpReq *http.Request) error {
+	resp, err := llmHTTPClient.Do(httpReq)
+	if err != nil {
+		return transportError(provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(provider, resp)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (p *openAIProvider) Name() string         { return p.name }
+func (p *openAIProvider) DefaultModel() string { return p.model }
+
+type openAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []ChatMessage         `json:"messages"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *openAIStreamOption   `json:"stream_options,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type openAIStreamOption struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+	// Groq reports streaming usage here instead of in "usage"
+	XGroq *struct {
+		Usage *Usage `json:"usage"`
+	} `json:"x_groq"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *openAIProvider) post(ctx context.Context, req ChatRequest, stream bool) (*http.Response, error) {
+	messages := make([]ChatMessage, 0, len(req.Messages)+1)
+	if req.System != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: req.System})
+	}
+	messages = append(messages, req.Messages...)
+
+	body := openAIRequest{Model: req.Model, Messages: messages, Stream: stream}
+	if stream && p.name != "groq" {
+		body.StreamOptions = &openAIStreamOption{IncludeUsage: true}
+	}
+	if req.JSONMode {
+		body.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+	}
+	jsonData, _ := json.Marshal(body)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := llmHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, transportError(p.name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(p.name, resp)
+	}
+	return resp, nil
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req ChatRequest) (string, Usage, error) {
+	resp, err := p.post(ctx, req, false)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var out openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", Usage{}, &LLMError{Kind: ErrKindBadResponse, Provider: p.name, StatusCode: resp.StatusCode, Err: err}
+	}
+	if len(out.Choices) == 0 {
+		return "", out.Usage, &LLMError{Kind: ErrKindEmpty, Provider: p.name, StatusCode: resp.StatusCode}
+	}
+	return out.Choices[0].Message.Content, out.Usage, nil
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string)) (string, Usage, error) {
+	resp, err := p.post(ctx, req, true)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var usage Usage
+	err = readSSE(resp.Body, func(_, data string) (bool, error) {
+		if data == "[DONE]" {
+			return true, nil
+		}
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return false, &LLMError{Kind: ErrKindBadResponse, Provider: p.name, Err: err}
+		}
+		if chunk.Error != nil {
+			return false, &LLMError{Kind: ErrKindUpstream, Provider: p.name, Err: errors.New(chunk.Error.Message)}
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		} else if chunk.XGroq != nil && chunk.XGroq.Usage != nil {
+			usage = *chunk.XGroq.Usage
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			text.WriteString(chunk.Choices[0].Delta.Content)
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return text.String(), usage, streamError(p.name, err)
+	}
+	return text.String(), usage, nil
+}
+
+// ============ ANTHROPIC MESSAGES API ============
+
+type anthropicProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+func newAnthropicProvider(apiKey, model string) *anthropicProvider {
+	if model == "" {
+		model = "claude-3-5-sonnet-latest"
+	}
+	return &anthropicProvider{
+		baseURL: strings.TrimRight(envOr("ANTHROPIC_BASE_URL", "https://api.anthropic.com/v1"), "/"),
+		apiKey:  apiKey,
+		model:   model,
+	}
+}
+
+// Ping lists the models, which needs a valid key but no tokens.
+func (p *anthropicProvider) Ping(ctx context.Context) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	return pingRequest(p.Name(), httpReq)
+}
+
+func (p *anthropicProvider) Name() string         { return "anthropic" }
+func (p *anthropicProvider) DefaultModel() string { return p.model }
+
+type anthropicRequest struct {
+	Model     string        `json:"model"`
+	System    string        `json:"system,omitempty"`
+	Messages  []ChatMessage `json:"messages"`
+	MaxTokens int           `json:"max_tokens"`
+	Stream    bool          `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage anthropicUsage `json:"usage"`
+}
+
+type anthropicStreamEvent struct {
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *anthropicProvider) post(ctx context.Context, req ChatRequest, stream bool) (*http.Response, error) {
+	system := req.System
+	if req.JSONMode {
+		// No response_format here; ask for it in the system prompt instead
+		system = strings.TrimSpace(system + "\n\nRespond with a single JSON object and nothing else.")
+	}
+	jsonData, _ := json.Marshal(anthropicRequest{
+		Model:     req.Model,
+		System:    system,
+		Messages:  req.Messages,
+		MaxTokens: 4096,
+		Stream:    stream,
+	})
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/messages", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := llmHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, transportError(p.Name(), err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(p.Name(), resp)
+	}
+	return resp, nil
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, req ChatRequest) (string, Usage, error) {
+	resp, err := p.post(ctx, req, false)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var out anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", Usage{}, &LLMError{Kind: ErrKindBadResponse, Provider: p.Name(), StatusCode: resp.StatusCode, Err: err}
+	}
+	if len(out.Content) == 0 {
+		return "", out.Usage.toUsage(), &LLMError{Kind: ErrKindEmpty, Provider: p.Name(), StatusCode: resp.StatusCode}
+	}
+
+	var text strings.Builder
+	for _, block := range out.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String(), out.Usage.toUsage(), nil
+}
+
+func (p *anthropicProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string)) (string, Usage, error) {
+	resp, err := p.post(ctx, req, true)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var usage anthropicUsage
+	err = readSSE(resp.Body, func(event, data string) (bool, error) {
+		switch event {
+		case "message_stop":
+			return true, nil
+		case "message_start":
+			var ev anthropicStreamEvent
+			if json.Unmarshal([]byte(data), &ev) == nil {
+				usage.InputTokens = ev.Message.Usage.InputTokens
+			}
+		case "message_delta":
+			var ev anthropicStreamEvent
+			if json.Unmarshal([]byte(data), &ev) == nil {
+				usage.OutputTokens = ev.Usage.OutputTokens
+			}
+		case "error":
+			var ev anthropicStreamEvent
+			json.Unmarshal([]byte(data), &ev)
+			kind := ErrKindUpstream
+			if ev.Error.Type == "rate_limit_error" {
+				kind = ErrKindRateLimited
+			}
+			return false, &LLMError{Kind: kind, Provider: p.Name(), Err: errors.New(ev.Error.Message)}
+		case "content_block_delta":
+			var ev anthropicStreamEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				return false, &LLMError{Kind: ErrKindBadResponse, Provider: p.Name(), Err: err}
+			}
+			if ev.Delta.Type == "text_delta" && ev.Delta.Text != "" {
+				text.WriteString(ev.Delta.Text)
+				onDelta(ev.Delta.Text)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return text.String(), usage.toUsage(), streamError(p.Name(), err)
+	}
+	return text.String(), usage.toUsage(), nil
+}
+
+func (u anthropicUsage) toUsage() Usage {
+	return Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// readSSE calls fn for every event of a text/event-stream body until fn
+// reports completion or the body ends.
+func readSSE(body io.Reader, fn func(event, data string) (bool, error)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			done, err := fn(event, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			if err != nil || done {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func streamError(provider string, err error) error {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return err
+	}
+	return transportError(provider, err)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
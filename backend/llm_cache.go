@@ -0,0 +1,210 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// llmCache is a content-addressed cache of completions: an in-memory LRU,
+// optionally backed by SQLite so entries survive restarts.
+type llmCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	db      *sql.DB
+	hits    int64
+	misses  int64
+}
+
+type cacheEntry struct {
+	key     string
+	comp    Completion
+	expires time.Time
+}
+
+var (
+	responseCache = newLLMCache(24*time.Hour, 500)
+	cacheRoutes   = make(map[string]bool)
+)
+
+func setupResponseCache() {
+	ttl, err := time.ParseDuration(envOr("LLM_CACHE_TTL", "24h"))
+	if err != nil {
+		log.Printf("LLM_CACHE_TTL: %v, using 24h", err)
+		ttl = 24 * time.Hour
+	}
+	size, err := strconv.Atoi(envOr("LLM_CACHE_SIZE", "500"))
+	if err != nil || size <= 0 {
+		size = 500
+	}
+	responseCache = newLLMCache(ttl, size)
+
+	if path := os.Getenv("LLM_CACHE_DB"); path != "" {
+		if err := responseCache.openDB(path); err != nil {
+			log.Printf("LLM cache: SQLite backing disabled: %v", err)
+		}
+	}
+
+	for _, route := range splitList(envOr("LLM_CACHE_ROUTES", "/api/b2a/schema,/api/ai-subject")) {
+		cacheRoutes[route] = true
+	}
+}
+
+func newLLMCache(ttl time.Duration, size int) *llmCache {
+	return &llmCache{ttl: ttl, size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (lc *llmCache) openDB(path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS llm_cache (
+		key TEXT PRIMARY KEY,
+		completion TEXT NOT NULL,
+		expires_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	db.Exec(`DELETE FROM llm_cache WHERE expires_at < ?`, time.Now().Unix())
+	lc.db = db
+	return nil
+}
+
+// cacheKey hashes everything that influences the answer.
+func cacheKey(provider string, req ChatRequest) string {
+	data, _ := json.Marshal(struct {
+		Provider string
+		Request  ChatRequest
+	}{provider, req})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type storedCompletion struct {
+	Completion
+	Text string `json:"text"`
+}
+
+func (lc *llmCache) Get(key string) (Completion, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if el, ok := lc.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			lc.order.MoveToFront(el)
+			lc.hits++
+			return entry.comp, true
+		}
+		lc.order.Remove(el)
+		delete(lc.entries, key)
+	}
+
+	if lc.db != nil {
+		var data string
+		var expires int64
+		err := lc.db.QueryRow(`SELECT completion, expires_at FROM llm_cache WHERE key = ?`, key).Scan(&data, &expires)
+		if err == nil && time.Now().Unix() < expires {
+			var stored storedCompletion
+			if json.Unmarshal([]byte(data), &stored) == nil {
+				comp := stored.Completion
+				comp.Text = stored.Text
+				lc.insert(key, comp, time.Unix(expires, 0))
+				lc.hits++
+				return comp, true
+			}
+		}
+	}
+
+	lc.misses++
+	return Completion{}, false
+}
+
+func (lc *llmCache) Put(key string, comp Completion) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	expires := time.Now().Add(lc.ttl)
+	lc.insert(key, comp, expires)
+	if lc.db != nil {
+		data, _ := json.Marshal(storedCompletion{Completion: comp, Text: comp.Text})
+		if _, err := lc.db.Exec(`INSERT OR REPLACE INTO llm_cache (key, completion, expires_at) VALUES (?, ?, ?)`,
+			key, string(data), expires.Unix()); err != nil {
+			log.Printf("LLM cache write error: %v", err)
+		}
+	}
+}
+
+// insert must be called with lc.mu held.
+func (lc *llmCache) insert(key string, comp Completion, expires time.Time) {
+	if el, ok := lc.entries[key]; ok {
+		el.Value = &cacheEntry{key: key, comp: comp, expires: expires}
+		lc.order.MoveToFront(el)
+		return
+	}
+	lc.entries[key] = lc.order.PushFront(&cacheEntry{key: key, comp: comp, expires: expires})
+	for lc.order.Len() > lc.size {
+		oldest := lc.order.Back()
+		lc.order.Remove(oldest)
+		delete(lc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len is the number of entries held in memory.
+func (lc *llmCache) Len() int {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.order.Len()
+}
+
+func (lc *llmCache) Stats() gin.H {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	backing := "memory"
+	if lc.db != nil {
+		backing = "sqlite"
+	}
+	return gin.H{
+		"hits":    lc.hits,
+		"misses":  lc.misses,
+		"entries": lc.order.Len(),
+		"ttl":     lc.ttl.String(),
+		"backing": backing,
+	}
+}
+
+type noCacheKey struct{}
+
+// withoutCache keeps the LLM calls made with ctx away from the cache
+// whatever the route, for callers that want a fresh answer every time.
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// cacheMode reports whether the request may read from and write to the cache.
+// X-Cache-Bypass: 1 (or Cache-Control: no-cache) skips the read but still
+// refreshes the entry.
+func cacheMode(ctx context.Context) (read, write bool) {
+	scope := scopeFromContext(ctx)
+	if !cacheRoutes[scope.Route] || ctx.Value(noCacheKey{}) != nil {
+		return false, false
+	}
+	return !scope.CacheBypass, true
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCacheMode(t *testing.T) {
+	cacheRoutes["/api/test-cached"] = true
+	defer delete(cacheRoutes, "/api/test-cached")
+	scoped := func(route string, bypass bool) context.Context {
+		return context.WithValue(context.Background(), callScopeKey{}, &callScope{Route: route, CacheBypass: bypass})
+	}
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		read, write bool
+	}{
+		{"cached route", scoped("/api/test-cached", false), true, true},
+		{"bypass header", scoped("/api/test-cached", true), false, true},
+		{"other route", scoped("/api/idea", false), false, false},
+		{"without cache", withoutCache(scoped("/api/test-cached", false)), false, false},
+	}
+	for _, tt := range tests {
+		if read, write := cacheMode(tt.ctx); read != tt.read || write != tt.write {
+			t.Errorf("%s: cacheMode = %v, %v, want %v, %v", tt.name, read, write, tt.read, tt.write)
+		}
+	}
+}
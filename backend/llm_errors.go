@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LLMErrorKind classifies failures of the LLM call path.
+type LLMErrorKind string
+
+const (
+	ErrKindRateLimited LLMErrorKind = "rate_limited"
+	ErrKindAuth        LLMErrorKind = "auth"
+	ErrKindUpstream    LLMErrorKind = "upstream"
+	ErrKindTimeout     LLMErrorKind = "timeout"
+	ErrKindEmpty       LLMErrorKind = "empty_response"
+	ErrKindBadResponse LLMErrorKind = "bad_response"
+	ErrKindRejected    LLMErrorKind = "rejected"
+	ErrKindUnavailable LLMErrorKind = "unavailable"
+	ErrKindNoModel     LLMErrorKind = "model_unavailable"
+)
+
+// LLMError is returned by providers and callLLM.
+type LLMError struct {
+	Kind       LLMErrorKind
+	Provider   string
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *LLMError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Provider, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *LLMError) Unwrap() error { return e.Err }
+
+// statusError builds an LLMError from a non-2xx provider response.
+func statusError(provider string, resp *http.Response) *LLMError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	e := &LLMError{Provider: provider, StatusCode: resp.StatusCode, Err: errors.New(string(body))}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrKindRateLimited
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.Kind = ErrKindAuth
+	case resp.StatusCode == http.StatusNotFound:
+		e.Kind = ErrKindNoModel
+	case resp.StatusCode >= 500:
+		e.Kind = ErrKindUpstream
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	default:
+		e.Kind = ErrKindRejected
+	}
+	return e
+}
+
+// transportError classifies errors returned by http.Client.Do.
+func transportError(provider string, err error) *LLMError {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &LLMError{Kind: ErrKindTimeout, Provider: provider, Err: err}
+	}
+	return &LLMError{Kind: ErrKindUnavailable, Provider: provider, Err: err}
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// respondLLMError writes the JSON error envelope for a failed LLM call:
+// {"error": "<message>", "code": "llm_<kind>"}.
+func respondLLMError(c *gin.Context, err error) {
+	status, body := llmErrorEnvelope(err)
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) && llmErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(llmErr.RetryAfter.Seconds()+0.5)))
+	}
+	c.JSON(status, body)
+}
+
+func llmErrorEnvelope(err error) (int, gin.H) {
+	var llmErr *LLMError
+	if errors.Is(err, context.DeadlineExceeded) && !errors.As(err, &llmErr) {
+		llmErr = &LLMError{Kind: ErrKindTimeout, Err: err}
+	}
+	if llmErr == nil && !errors.As(err, &llmErr) {
+		return http.StatusInternalServerError, gin.H{"error": err.Error(), "code": "internal"}
+	}
+
+	status := http.StatusBadGateway
+	message := "AI provider error"
+	switch llmErr.Kind {
+	case ErrKindRateLimited:
+		status = http.StatusTooManyRequests
+		message = "AI provider is rate limited, try again later"
+	case ErrKindTimeout:
+		status = http.StatusGatewayTimeout
+		message = "AI provider timed out"
+	case ErrKindUnavailable:
+		status = http.StatusServiceUnavailable
+		message = "AI provider is unavailable"
+	case ErrKindAuth:
+		message = "AI provider rejected our credentials"
+	case ErrKindEmpty:
+		message = "AI provider returned no response"
+	case ErrKindBadResponse:
+		message = "AI provider returned a malformed response"
+	case ErrKindRejected:
+		message = "AI provider rejected the request"
+	case ErrKindNoModel:
+		message = "AI model is unavailable"
+	}
+
+	log.Printf("LLM error: %v", llmErr)
+	return status, gin.H{"error": message, "code": "llm_" + string(llmErr.Kind)}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// errSchemaMismatch is wrapped by generateJSON when the model keeps
+// answering with JSON that does not match the schema.
+var errSchemaMismatch = errors.New("answer does not match schema")
+
+// jsonSchema is the subset of JSON Schema we need to constrain model output.
+type jsonSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	MinItems             int                    `json:"minItems,omitempty"`
+}
+
+// schemaFor derives a schema from a Go type using its json tags. Fields
+// tagged `jsonschema:"required"` are required.
+func schemaFor(t reflect.Type) *jsonSchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Map:
+		s := &jsonSchema{Type: "object"}
+		if t.Elem().Kind() != reflect.Interface {
+			s.AdditionalProperties = schemaFor(t.Elem())
+		}
+		return s
+	case reflect.Struct:
+		s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" || !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = schemaFor(f.Type)
+			if f.Tag.Get("jsonschema") == "required" {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	// interface{} and anything exotic: accept any value
+	return &jsonSchema{}
+}
+
+// Validate returns a human-readable list of violations, empty when v
+// (as decoded by encoding/json) conforms.
+func (s *jsonSchema) Validate(v interface{}) []string {
+	var errs []string
+	s.validate("$", v, &errs)
+	return errs
+}
+
+func (s *jsonSchema) validate(path string, v interface{}, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case "":
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fail("expected object, got %s", jsonTypeName(v))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				prop.validate(path+"."+k, obj[k], errs)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(path+"."+k, obj[k], errs)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			fail("expected array, got %s", jsonTypeName(v))
+			return
+		}
+		if len(arr) < s.MinItems {
+			fail("expected at least %d items, got %d", s.MinItems, len(arr))
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("expected string, got %s", jsonTypeName(v))
+			return
+		}
+		if len(s.Enum) > 0 && !containsString(s.Enum, str) {
+			fail("%q is not one of the allowed values", str)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("expected boolean, got %s", jsonTypeName(v))
+		}
+	case "number", "integer":
+		n, ok := v.(float64)
+		if !ok {
+			fail("expected %s, got %s", s.Type, jsonTypeName(v))
+		} else if s.Type == "integer" && n != float64(int64(n)) {
+			fail("expected integer, got %v", n)
+		}
+	}
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// extractJSON pulls the first complete JSON object out of a model answer,
+// tolerating markdown fences and prose around it.
+func extractJSON(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if i := strings.Index(text, "```"); i >= 0 {
+		fenced := text[i+3:]
+		// Skip the language tag on the opening fence
+		if nl := strings.IndexByte(fenced, '\n'); nl >= 0 {
+			fenced = fenced[nl+1:]
+		}
+		if end := strings.Index(fenced, "```"); end >= 0 {
+			fenced = fenced[:end]
+		}
+		if strings.Contains(fenced, "{") {
+			text = fenced
+		}
+	}
+
+	start := strings.IndexByte(text, '{')
+	if start < 0 {
+		return "", errors.New("no JSON object found")
+	}
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && ch == '\\':
+			escaped = true
+		case ch == '"':
+			inString = !inString
+		case inString:
+		case ch == '{':
+			depth++
+		case ch == '}':
+			depth--
+			if depth == 0 {
+				return text[start : i+1], nil
+			}
+		}
+	}
+	return "", errors.New("unterminated JSON object")
+}
+
+// generateJSON asks for a JSON answer conforming to schema, decodes it into
+// out and re-prompts with the validation errors up to maxRepairs times. The
+// last raw answer is returned even on failure.
+func generateJSON(ctx context.Context, req ChatRequest, schema *jsonSchema, out interface{}, maxRepairs int) (string, error) {
+	req.JSONMode = true
+	messages := append([]ChatMessage(nil), req.Messages...)
+
+	var raw string
+	for attempt := 0; ; attempt++ {
+		req.Messages = messages
+		comp, err := completeLLM(ctx, req)
+		if err != nil {
+			return raw, err
+		}
+		raw = comp.Text
+
+		problems := validateJSONAnswer(raw, schema, out)
+		if len(problems) == 0 {
+			return raw, nil
+		}
+		if attempt >= maxRepairs {
+			return raw, &LLMError{Kind: ErrKindBadResponse, Provider: comp.Provider,
+				Err: fmt.Errorf("%w: %s", errSchemaMismatch, strings.Join(problems, "; "))}
+		}
+
+		messages = append(messages,
+			ChatMessage{Role: "assistant", Content: raw},
+			ChatMessage{Role: "user", Content: "The JSON above is invalid:\n- " + strings.Join(problems, "\n- ") +
+				"\nReturn the corrected JSON object only, without markdown or comments."},
+		)
+	}
+}
+
+func validateJSONAnswer(raw string, schema *jsonSchema, out interface{}) []string {
+	obj, err := extractJSON(raw)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var generic interface{}
+	if err := json.Unmarshal([]byte(obj), &generic); err != nil {
+		return []string{"invalid JSON: " + err.Error()}
+	}
+	if problems := schema.Validate(generic); len(problems) > 0 {
+		return problems
+	}
+	if err := json.Unmarshal([]byte(obj), out); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
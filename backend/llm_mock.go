@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// mockFixture scripts the mock provider's answer for calls whose system
+// prompt (and optionally user prompt) contains the given substrings.
+type mockFixture struct {
+	System    string   `json:"system"`
+	Prompt    string   `json:"prompt,omitempty"`
+	Responses []string `json:"responses"`
+	Error     string   `json:"error,omitempty"`     // an LLMErrorKind to fail with
+	Malformed bool     `json:"malformed,omitempty"` // cut the response in half, breaking JSON
+	LatencyMS int      `json:"latency_ms,omitempty"`
+
+	calls int
+}
+
+// mockProvider is an offline Provider for tests and local development.
+// It is enabled with LLM_PROVIDER=mock (or MOCK_LLM_ENABLED=1) and reads
+// MOCK_LLM_FIXTURES, MOCK_LLM_LATENCY_MS and MOCK_LLM_ERROR.
+type mockProvider struct {
+	mu       sync.Mutex
+	fixtures []*mockFixture
+	latency  time.Duration
+	failWith LLMErrorKind
+}
+
+func newMockProvider() *mockProvider {
+	return &mockProvider{}
+}
+
+func newMockProviderFromEnv() (*mockProvider, error) {
+	m := newMockProvider()
+	if path := os.Getenv("MOCK_LLM_FIXTURES"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("MOCK_LLM_FIXTURES: %w", err)
+		}
+		if err := json.Unmarshal(data, &m.fixtures); err != nil {
+			return nil, fmt.Errorf("MOCK_LLM_FIXTURES: %w", err)
+		}
+	}
+	if ms, err := strconv.Atoi(os.Getenv("MOCK_LLM_LATENCY_MS")); err == nil {
+		m.latency = time.Duration(ms) * time.Millisecond
+	}
+	m.failWith = LLMErrorKind(os.Getenv("MOCK_LLM_ERROR"))
+	return m, nil
+}
+
+// Script adds a fixture answering calls whose system prompt contains system.
+// Responses are returned in order; the last one repeats.
+func (m *mockProvider) Script(system string, responses ...string) *mockFixture {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := &mockFixture{System: system, Responses: responses}
+	m.fixtures = append(m.fixtures, f)
+	return f
+}
+
+func (m *mockProvider) Name() string         { return "mock" }
+func (m *mockProvider) DefaultModel() string { return "mock-1" }
+
+func (m *mockProvider) Complete(ctx context.Context, req ChatRequest) (string, Usage, error) {
+	text, latency, err := m.answer(req)
+	if !sleepCtx(ctx, latency) {
+		return "", Usage{}, transportError(m.Name(), ctx.Err())
+	}
+	if err != nil {
+		return "", Usage{}, err
+	}
+	return text, mockUsage(req, text), nil
+}
+
+// Ping fails with MOCK_LLM_ERROR when it is set.
+func (m *mockProvider) Ping(ctx context.Context) error {
+	if m.failWith != "" {
+		return &LLMError{Kind: m.failWith, Provider: m.Name()}
+	}
+	return nil
+}
+
+func (m *mockProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string)) (string, Usage, error) {
+	text, latency, err := m.answer(req)
+	if err != nil {
+		if !sleepCtx(ctx, latency) {
+			return "", Usage{}, transportError(m.Name(), ctx.Err())
+		}
+		return "", Usage{}, err
+	}
+
+	words := strings.SplitAfter(text, " ")
+	step := latency / time.Duration(len(words))
+	for _, w := range words {
+		if !sleepCtx(ctx, step) {
+			return "", Usage{}, transportError(m.Name(), ctx.Err())
+		}
+		onDelta(w)
+	}
+	return text, mockUsage(req, text), nil
+}
+
+func (m *mockProvider) answer(req ChatRequest) (string, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prompt := ""
+	if len(req.Messages) > 0 {
+		prompt = req.Messages[len(req.Messages)-1].Content
+	}
+
+	latency := m.latency
+	failWith := m.failWith
+	text := "[mock] " + truncateRunes(prompt, 80)
+	malformed := false
+
+	for _, f := range m.fixtures {
+		if !strings.Contains(req.System, f.System) || !strings.Contains(prompt, f.Prompt) {
+			continue
+		}
+		if f.LatencyMS > 0 {
+			latency = time.Duration(f.LatencyMS) * time.Millisecond
+		}
+		if f.Error != "" {
+			failWith = LLMErrorKind(f.Error)
+		}
+		if len(f.Responses) > 0 {
+			i := f.calls
+			if i >= len(f.Responses) {
+				i = len(f.Responses) - 1
+			}
+			text = f.Responses[i]
+		}
+		malformed = f.Malformed
+		f.calls++
+		break
+	}
+
+	if failWith != "" {
+		return "", latency, m.simulatedError(failWith)
+	}
+	if malformed {
+		text = truncateRunes(text, utf8.RuneCountInString(text)/2)
+	}
+	return text, latency, nil
+}
+
+func (m *mockProvider) simulatedError(kind LLMErrorKind) *LLMError {
+	e := &LLMError{Kind: kind, Provider: m.Name(), Err: fmt.Errorf("simulated %s", kind)}
+	switch kind {
+	case ErrKindRateLimited:
+		e.StatusCode = 429
+	case ErrKindAuth:
+		e.StatusCode = 401
+	case ErrKindUpstream:
+		e.StatusCode = 503
+	case ErrKindRejected:
+		e.StatusCode = 400
+	case ErrKindNoModel:
+		e.StatusCode = 404
+	}
+	return e
+}
+
+// mockUsage approximates tokens as four bytes each.
+func mockUsage(req ChatRequest, text string) Usage {
+	in := len(req.System)
+	for _, msg := range req.Messages {
+		in += len(msg.Content)
+	}
+	u := Usage{PromptTokens: in / 4, CompletionTokens: len(text) / 4}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Completion is the result of an LLM call together with the metadata of
+// the attempt that actually answered.
+type Completion struct {
+	Text     string `json:"-"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Attempts int    `json:"attempts"`
+	Usage    Usage  `json:"usage"`
+	Cached   bool   `json:"cached,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+}
+
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+var (
+	llmRetry     = retryPolicy{MaxRetries: 2, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second}
+	llmFallbacks []string
+)
+
+// loadRetryConfig reads LLM_MAX_RETRIES, LLM_RETRY_BASE_MS, LLM_RETRY_MAX_MS
+// and the global LLM_FALLBACKS chain ("provider[:model]" entries).
+func loadRetryConfig() {
+	if v, err := strconv.Atoi(os.Getenv("LLM_MAX_RETRIES")); err == nil && v >= 0 {
+		llmRetry.MaxRetries = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LLM_RETRY_BASE_MS")); err == nil && v > 0 {
+		llmRetry.BaseDelay = time.Duration(v) * time.Millisecond
+	}
+	if v, err := strconv.Atoi(os.Getenv("LLM_RETRY_MAX_MS")); err == nil && v > 0 {
+		llmRetry.MaxDelay = time.Duration(v) * time.Millisecond
+	}
+	for _, spec := range splitList(os.Getenv("LLM_FALLBACKS")) {
+		if _, _, err := resolveProvider(spec); err != nil {
+			log.Printf("LLM_FALLBACKS: skipping %q: %v", spec, err)
+			continue
+		}
+		llmFallbacks = append(llmFallbacks, spec)
+	}
+}
+
+// backoff returns how long to wait before retry number try+1, or false if
+// the error is not worth retrying on the same model.
+func (rp retryPolicy) backoff(try int, err error) (time.Duration, bool) {
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) || try >= rp.MaxRetries {
+		return 0, false
+	}
+	switch llmErr.Kind {
+	case ErrKindRateLimited, ErrKindUpstream, ErrKindTimeout, ErrKindUnavailable, ErrKindEmpty:
+	default:
+		return 0, false
+	}
+
+	delay := rp.BaseDelay << uint(try)
+	if delay <= 0 || delay > rp.MaxDelay {
+		delay = rp.MaxDelay
+	}
+	// Jitter in [delay/2, delay) so the supervisor workers don't retry in lockstep
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	if llmErr.RetryAfter > 0 {
+		if llmErr.RetryAfter > rp.MaxDelay {
+			// Waiting that long is worse than trying the next model
+			return 0, false
+		}
+		if llmErr.RetryAfter > delay {
+			delay = llmErr.RetryAfter
+		}
+	}
+	return delay, true
+}
+
+type llmCandidate struct {
+	provider Provider
+	model    string
+}
+
+// llmCandidates returns the primary provider for ctx followed by its
+// fallbacks: first the "|"-separated chain of the request or route spec,
+// then LLM_FALLBACKS.
+func llmCandidates(ctx context.Context) ([]llmCandidate, error) {
+	spec, _ := ctx.Value(llmSpecKey{}).(string)
+	specs := append(strings.Split(spec, "|"), llmFallbacks...)
+
+	var out []llmCandidate
+	seen := make(map[string]bool)
+	for _, s := range specs {
+		p, model, err := resolveProvider(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		key := p.Name() + ":" + model
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, llmCandidate{provider: p, model: model})
+	}
+	return out, nil
+}
+
+// runWithFallbacks calls each candidate in order, retrying transient errors
+// with jittered exponential backoff and moving on to the next one only when
+// the last error fallsBack. Every attempt that reports usage is recorded,
+// failed ones included. canRetry is consulted before every retry;
+// streaming calls use it to stop once output reached the client.
+func runWithFallbacks(ctx context.Context, req ChatRequest, call func(Provider, ChatRequest) (string, Usage, error), canRetry func() bool) (Completion, error) {
+	candidates, err := llmCandidates(ctx)
+	if err != nil {
+		return Completion{}, err
+	}
+
+	var lastErr error
+	attempts := 0
+	for i, cand := range candidates {
+		r := req
+		r.Model = cand.model
+		for try := 0; ; try++ {
+			attempts++
+			started := time.Now()
+			text, usage, err := call(cand.provider, r)
+			if err == nil && strings.TrimSpace(text) == "" {
+				err = &LLMError{Kind: ErrKindEmpty, Provider: cand.provider.Name()}
+			}
+			observeLLMCall(cand.provider.Name(), cand.model, time.Since(started), usage, err)
+			// A failed attempt that reports tokens was still billed
+			if err != nil && usage != (Usage{}) {
+				recordUsage(ctx, Completion{Provider: cand.provider.Name(), Model: cand.model, Usage: usage, Prompt: req.PromptID})
+			}
+			if err == nil {
+				if attempts > 1 {
+					log.Printf("LLM %s/%s answered after %d attempts", cand.provider.Name(), cand.model, attempts)
+				}
+				comp := Completion{Text: text, Provider: cand.provider.Name(), Model: cand.model, Attempts: attempts, Usage: usage, Prompt: req.PromptID}
+				recordUsage(ctx, comp)
+				return comp, nil
+			}
+
+			lastErr = err
+			if ctx.Err() != nil || !canRetry() {
+				return Completion{Text: text, Attempts: attempts}, err
+			}
+			delay, ok := llmRetry.backoff(try, err)
+			if !ok {
+				break
+			}
+			log.Printf("LLM %s/%s failed (%v), retrying in %s", cand.provider.Name(), cand.model, err, delay)
+			if !sleepCtx(ctx, delay) {
+				return Completion{Attempts: attempts}, lastErr
+			}
+		}
+
+		if !fallsBack(lastErr) {
+			break
+		}
+		if i+1 < len(candidates) {
+			next := candidates[i+1]
+			log.Printf("LLM %s/%s gave up (%v), falling back to %s/%s", cand.provider.Name(), cand.model, lastErr, next.provider.Name(), next.model)
+		}
+	}
+	return Completion{Attempts: attempts}, lastErr
+}
+
+// fallsBack reports whether err is worth trying the next model: transient
+// failures and models the provider does not serve. Bad credentials and
+// rejected requests would fail the same way everywhere, so they are
+// returned as they are.
+func fallsBack(err error) bool {
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) {
+		return false
+	}
+	switch llmErr.Kind {
+	case ErrKindRateLimited, ErrKindUpstream, ErrKindTimeout, ErrKindUnavailable, ErrKindEmpty, ErrKindNoModel:
+		return true
+	}
+	return false
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
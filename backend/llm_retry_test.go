@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ezhik-ideas/store"
+)
+
+func TestFailedAttemptUsageRecorded(t *testing.T) {
+	r, mock := newTestServer(t)
+	mock.Script("helpful AI assistant", " ", "Hi")
+
+	var got struct {
+		Meta Completion `json:"meta"`
+	}
+	if w := do(t, r, "POST", "/api/ai?lang=en", map[string]string{"user_id": "1", "prompt": "hello"}, &got); w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if got.Meta.Attempts != 2 {
+		t.Fatalf("answered after %d attempts, want 2", got.Meta.Attempts)
+	}
+
+	rows, err := db.ListUsage(store.UsageFilter{UserID: "1"})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("usage = %+v, %v", rows, err)
+	}
+	if rows[0].Calls != 2 || rows[0].PromptTokens <= got.Meta.Usage.PromptTokens {
+		t.Errorf("usage = %+v, want both attempts counted", rows[0])
+	}
+}
+
+func TestFallbackOnlyOnTransientErrors(t *testing.T) {
+	_, mock := newTestServer(t)
+	ctx := context.WithValue(context.Background(), llmSpecKey{}, "mock:mock-1|mock:mock-2")
+
+	for _, tc := range []struct {
+		kind  LLMErrorKind
+		calls []string
+	}{
+		{ErrKindAuth, []string{"mock-1"}},
+		{ErrKindRejected, []string{"mock-1"}},
+		{ErrKindBadResponse, []string{"mock-1"}},
+		{ErrKindNoModel, []string{"mock-1", "mock-2"}},
+		{ErrKindRateLimited, []string{"mock-1", "mock-1", "mock-2"}},
+		{ErrKindUpstream, []string{"mock-1", "mock-1", "mock-2"}},
+	} {
+		var calls []string
+		_, err := runWithFallbacks(ctx, ChatRequest{}, func(p Provider, req ChatRequest) (string, Usage, error) {
+			calls = append(calls, req.Model)
+			if req.Model == "mock-1" {
+				return "", Usage{}, mock.simulatedError(tc.kind)
+			}
+			return "Hi", Usage{}, nil
+		}, func() bool { return true })
+		if strings.Join(calls, ",") != strings.Join(tc.calls, ",") {
+			t.Errorf("%s: called %v, want %v", tc.kind, calls, tc.calls)
+		}
+		if fellBack := tc.calls[len(tc.calls)-1] == "mock-2"; fellBack != (err == nil) {
+			t.Errorf("%s: err = %v", tc.kind, err)
+		}
+	}
+}
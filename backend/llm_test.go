@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProviderOverrideAllowList(t *testing.T) {
+	r, _ := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	prev := llmAllowedOverrides
+	t.Cleanup(func() { llmAllowedOverrides = prev })
+
+	for _, tc := range []struct {
+		allowed []string
+		spec    string
+		admin   bool
+		want    int
+	}{
+		{nil, "", false, http.StatusOK},
+		{nil, "mock", false, http.StatusForbidden},
+		{nil, "mock:mock-2", true, http.StatusOK},
+		{[]string{"mock"}, "mock:mock-2", false, http.StatusOK},
+		{[]string{"mock:mock-1"}, "mock:mock-1", false, http.StatusOK},
+		{[]string{"mock:mock-1"}, "mock:mock-2", false, http.StatusForbidden},
+		{[]string{"mock:mock-1"}, "mock:mock-1|mock:mock-2", false, http.StatusForbidden},
+	} {
+		llmAllowedOverrides = make(map[string]bool)
+		for _, s := range tc.allowed {
+			llmAllowedOverrides[s] = true
+		}
+		req := httptest.NewRequest("POST", "/api/ai", strings.NewReader(`{"prompt": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-LLM-Provider", tc.spec)
+		if tc.admin {
+			req.Header.Set("X-Admin-Token", "secret")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%q allowed %v admin %v: %d %s, want %d", tc.spec, tc.allowed, tc.admin, w.Code, w.Body, tc.want)
+		}
+	}
+}
+
+func TestAnthropicEmptyContent(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"content": [], "usage": {"input_tokens": 5, "output_tokens": 0}}`))
+	}))
+	defer api.Close()
+	p := newAnthropicProvider("key", "")
+	p.baseURL = api.URL
+
+	_, _, err := p.Complete(context.Background(), ChatRequest{Model: p.model})
+	var le *LLMError
+	if !errors.As(err, &le) || le.Kind != ErrKindEmpty {
+		t.Errorf("err = %v, want %s", err, ErrKindEmpty)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,47 +13,34 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
+	"ezhik-ideas/store"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
-var statsCount int
-var premiumUsers = make(map[string]bool)
-const premiumFile = "premium_users.json"
-
-func loadPremiumUsers() {
-	data, err := os.ReadFile(premiumFile)
-	if err != nil {
-		return
-	}
-	json.Unmarshal(data, &premiumUsers)
-}
-
-func savePremiumUsers() {
-	data, _ := json.Marshal(premiumUsers)
-	os.WriteFile(premiumFile, data, 0644)
-}
-
 type Idea struct {
 	ID        int    `json:"id"`
 	Text      string `json:"idea"`
 	Category  string `json:"category"`
 }
 
-type Stats struct {
-	Count int `json:"count"`
-}
-
 type FeedbackRequest struct {
-	Idea    string `json:"idea" binding:"required"`
+	IdeaID   int64  `json:"idea_id"`
+	Idea     string `json:"idea"`
+	Category string `json:"category"`
 	Feedback string `json:"feedback" binding:"required"`
 }
 
 type AIRequest struct {
 	Prompt      string `json:"prompt" binding:"required"`
 	SystemPrompt string `json:"systemPrompt"`
+	UserID      string `json:"user_id"`
+	SessionID   string `json:"session_id"`
 }
 
 type YouTubeRequest struct {
@@ -60,40 +49,99 @@ type YouTubeRequest struct {
 }
 
 func main() {
+	godotenv.Load()
 	godotenv.Load("/root/.openclaw/workspace/ezhik-ideas/backend/.env")
-	loadPremiumUsers()
-	// Setup router
+	if err := setupLLM(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupStore(); err != nil {
+		log.Fatal(err)
+	}
+	setupSessions()
+	setupTelegramAuth()
+	setupEntitlements()
+	setupStars()
+	setupX402()
+	setupTON()
+	setupIdeas()
+	setupEmailBuilder()
+	if err := setupPrompts(); err != nil {
+		log.Fatal(err)
+	}
+	r := setupRouter()
+
+	// Start server
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	log.Printf("Server started on port %s", port)
+	r.Run("0.0.0.0:" + port)
+}
+
+// setupRouter wires middleware and routes; tests drive it with httptest.
+func setupRouter() *gin.Engine {
 	r := gin.Default()
 	
 	// CORS
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, X-LLM-Provider, X-Cache-Bypass, X-Locale, X-Telegram-Init-Data, Authorization, X-PAYMENT")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-PAYMENT-RESPONSE, ETag")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 		c.Next()
 	})
+	r.Use(metricsMiddleware())
+	r.Use(llmRouting())
+	r.Use(statsMiddleware())
 
 	// API routes
-	r.GET("/api/idea", getIdea)
+	r.GET("/api/idea", optionalTelegramUser(), getIdea)
+	r.GET("/api/idea/categories", handleIdeaCategories)
 	r.GET("/api/stats", getStats)
-	r.POST("/api/feedback", sendFeedback)
-	r.POST("/api/ai", handleAI)
+	r.POST("/api/feedback", optionalTelegramUser(), sendFeedback)
+	r.GET("/api/ideas/top", handleTopIdeas)
+	r.GET("/api/ideas/history", optionalTelegramUser(), handleIdeaHistory)
+	r.GET("/api/ideas/search", handleSearchIdeas)
+	r.GET("/api/ideas/:id", handleGetIdea)
+	r.POST("/api/ai", optionalTelegramUser(), handleAI)
+	r.POST("/api/chat/sessions", optionalTelegramUser(), handleCreateSession)
+	r.GET("/api/chat/sessions", optionalTelegramUser(), handleListSessions)
+	r.GET("/api/chat/sessions/:id", optionalTelegramUser(), handleGetSession)
+	r.POST("/api/chat/sessions/:id/messages", optionalTelegramUser(), handleSessionMessage)
+	r.DELETE("/api/chat/sessions/:id", optionalTelegramUser(), handleDeleteSession)
 	r.POST("/api/youtube-dl", downloadYouTube)
 	r.POST("/api/code", generateCode)
-	r.POST("/api/stars/check", checkStars)
-	r.POST("/api/stars/pay", handleStarsPay)
-	r.POST("/api/pro-brainstorm", handleProBrainstorm)
-	r.POST("/api/supervisor/startup", handleSupervisorStartup)
-	r.POST("/api/supervisor/marketing", handleSupervisorMarketing)
-	r.POST("/api/supervisor/pce", handlePlannerCriticExecutor)
-	r.POST("/api/supervisor/ralph", handleRalphMode)
+	r.POST("/api/stars/check", requireTelegramUser(), checkStars)
+	r.POST("/api/stars/pay", requireTelegramUser(), handleStarsPay)
+	r.POST("/api/telegram/webhook", handleTelegramWebhook)
+	r.GET("/api/entitlements", requireTelegramUser(), handleEntitlements)
+	r.GET("/api/x402/receipts/:id", handleX402Receipt)
+	r.POST("/api/entitlements/grant", requireAdmin(), handleGrantEntitlement)
+	r.POST("/api/pro-brainstorm", optionalTelegramUser(), requireFeature("pro-brainstorm"), handleProBrainstorm)
+	r.POST("/api/supervisor/startup", optionalTelegramUser(), requireFeature("startup-builder"), handleSupervisorStartup)
+	r.POST("/api/supervisor/marketing", optionalTelegramUser(), requireFeature("outreach-drafter"), handleSupervisorMarketing)
+	r.POST("/api/supervisor/pce", optionalTelegramUser(), requireFeature("planner-critic-executor"), handlePlannerCriticExecutor)
+	r.POST("/api/supervisor/ralph", optionalTelegramUser(), requireFeature("ralph"), handleRalphMode)
 	r.POST("/api/b2a/schema", handleB2ASchema)
+	r.GET("/api/b2a/schema", handleAssetSchema)
 	r.GET("/api/b2a/assets", handleGetAssets)
+	r.GET("/api/b2a/assets/:id", handleGetAsset)
+	r.POST("/api/b2a/assets", requireAdmin(), handleCreateAsset)
+	r.PUT("/api/b2a/assets/:id", requireAdmin(), handleUpdateAsset)
+	r.DELETE("/api/b2a/assets/:id", requireAdmin(), handleDeleteAsset)
+	r.GET("/api/b2a/assets/:id/download", optionalTelegramUser(), handleAssetDownload)
+	r.POST("/api/ton/orders", optionalTelegramUser(), handleCreateTonOrder)
+	r.GET("/api/ton/orders/:id", handleGetTonOrder)
 	r.GET("/api/diagnostics", handleDiagnostics)
+	r.GET("/metrics", handleMetrics)
+	r.GET("/api/usage", requireAdmin(), handleUsage)
+	r.GET("/api/prompts", requireAdmin(), handleListPrompts)
+	r.POST("/api/prompts/reload", requireAdmin(), handleReloadPrompts)
 	
 	// UCP (Universal Commerce Protocol) mock for B2A discovery
 	r.GET("/.well-known/ucp", handleUCPDiscovery)
@@ -133,13 +181,7 @@ func main() {
 		c.File("./frontend/app.js")
 	})
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Printf("Server started on port %s", port)
-	r.Run("0.0.0.0:" + port)
+	return r
 }
 
 func downloadYouTube(c *gin.Context) {
@@ -210,108 +252,135 @@ func downloadYouTube(c *gin.Context) {
 }
 
 func getIdea(c *gin.Context) {
-	category := c.DefaultQuery("category", "бизнес")
-	ideaText := generateIdea(c.Request.Context(), category)
-	statsCount++
+	cat, _ := lookupCategory(c.Query("category"))
+	userID := callerID(c)
+	setCallUser(c, userID)
+	ctx := c.Request.Context()
+	if cat.Model != "" && c.GetHeader("X-LLM-Provider") == "" {
+		ctx = context.WithValue(ctx, llmSpecKey{}, cat.Model)
+	}
+	res, err := generateIdea(ctx, cat)
+	if err != nil {
+		respondLLMError(c, err)
+		return
+	}
+	idea := store.Idea{UserID: userID, Category: cat.ID, Raw: res.Draft, Text: res.Final}
+	if err := db.AddIdea(&idea); err != nil {
+		log.Printf("Idea save error: %v", err)
+	}
+	if _, err := db.Incr("ideas_generated", 1); err != nil {
+		log.Printf("Stats error: %v", err)
+	}
+	recordStat("ideas", "", cat.ID)
 	
 	c.JSON(http.StatusOK, gin.H{
-		"idea":    ideaText,
-		"category": category,
+		"id":       idea.ID,
+		"idea":     res.Final,
+		"category": cat.ID,
+		"notes":    res.Notes,
+		"score":    res.Score,
+		"rounds":   res.Rounds,
 	})
 }
 
-func getStats(c *gin.Context) {
-	c.JSON(http.StatusOK, Stats{Count: statsCount})
-}
-
+// sendFeedback records the caller's vote; it ranks top ideas and picks the
+// generator's examples, so anonymous votes are not taken.
 func sendFeedback(c *gin.Context) {
+	userID := callerID(c)
+	if userID == "" {
+		abortNoCaller(c)
+		return
+	}
 	var req FeedbackRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
-	log.Printf("Feedback: %s - %s", req.Idea, req.Feedback)
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	if req.Feedback != "like" && req.Feedback != "dislike" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "feedback must be like or dislike"})
+		return
+	}
+	if req.IdeaID == 0 && req.Idea == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "idea_id or idea is required"})
+		return
+	}
+
+	fb := store.Feedback{IdeaText: req.Idea, Category: req.Category, UserID: userID, Value: req.Feedback}
+	if cat, ok := lookupCategory(req.Category); ok && req.Category != "" {
+		fb.Category = cat.ID
+	}
+	var idea store.Idea
+	var err error
+	if req.IdeaID != 0 {
+		idea, err = db.GetIdea(req.IdeaID)
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Idea not found"})
+			return
+		}
+	} else {
+		// Older clients only send the text; attach it to the stored idea if we have one
+		idea, err = db.FindIdeaByText(req.Idea)
+	}
+	if err == nil {
+		fb.IdeaID = &idea.ID
+		fb.IdeaText = idea.Text
+		fb.Category = idea.Category
+	} else if !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setCallUser(c, userID)
+	if err := db.AddFeedback(&fb); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordStat(fb.Value, "", fb.Category)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "id": fb.ID, "idea_id": fb.IdeaID})
 }
 
 func handleAI(c *gin.Context) {
+	userID := callerID(c)
 	var req AIRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
-	systemPrompt := req.SystemPrompt
-	if systemPrompt == "" {
-		systemPrompt = "Ты полезный AI-ассистент. Отвечай на русском языке."
+	if req.SessionID != "" {
+		s, ok := getSession(req.SessionID, userID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		chatInSession(c, s, req.Prompt)
+		return
 	}
-	
-	response := callGroq(c.Request.Context(), req.Prompt, systemPrompt)
-	c.JSON(http.StatusOK, gin.H{"response": response})
-}
 
-func callGroq(ctx context.Context, prompt, systemPrompt string) string {
-	client := &http.Client{}
-	
-	reqBody := GroqRequest{
-		Model: "llama-3.3-70b-versatile",
-		Messages: []GroqMessage{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: prompt},
-		},
-	}
-	
-	jsonData, _ := json.Marshal(reqBody)
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.groq.com/openai/v1/chat/completions", bytes.NewBuffer(jsonData))
+	setCallUser(c, userID)
+	p, err := renderPrompt(c.Request.Context(), "chat.default", gin.H{"Input": req.Prompt})
 	if err != nil {
-		return "Error: " + err.Error()
+		respondLLMError(c, err)
+		return
 	}
-	req.Header.Set("Authorization", "Bearer "+GroqAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := client.Do(req)
-	if err != nil {
-		return "Error: " + err.Error()
+	chatReq := p.Request()
+	if req.SystemPrompt != "" {
+		chatReq.System = req.SystemPrompt
+		chatReq.PromptID = ""
 	}
-	defer resp.Body.Close()
-	
-	var groqResp GroqResponse
-	json.NewDecoder(resp.Body).Decode(&groqResp)
 	
-	if len(groqResp.Choices) > 0 {
-		return groqResp.Choices[0].Message.Content
+	if wantsStream(c) {
+		streamChatCompletion(c, chatReq, nil)
+		return
 	}
-	
-	return "No response from AI"
-}
-
-var GroqAPIKey string
 
-func init() {
-	godotenv.Load()
-	GroqAPIKey = os.Getenv("GROQ_API_KEY")
-	if GroqAPIKey == "" {
-		log.Fatal("GROQ_API_KEY not set in environment or .env file")
+	comp, err := completeLLM(c.Request.Context(), chatReq)
+	if err != nil {
+		respondLLMError(c, err)
+		return
 	}
-}
-
-type GroqMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type GroqRequest struct {
-	Model    string        `json:"model"`
-	Messages []GroqMessage `json:"messages"`
-}
-
-type GroqResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+	c.JSON(http.StatusOK, gin.H{"response": comp.Text, "meta": comp})
 }
 
 func generateCode(c *gin.Context) {
@@ -324,27 +393,12 @@ func generateCode(c *gin.Context) {
 		return
 	}
 
-	prompt := fmt.Sprintf("Generate working code in %s for: %s. Return only the code, no explanations. Include comments if needed. Make it complete and runnable.", req.Language, req.Task)
-	response := callGroq(c.Request.Context(), prompt, "You are an expert coder.")
-	c.JSON(http.StatusOK, gin.H{"code": response})
-}
-
-func generateIdea(ctx context.Context, category string) string {
-	var prompt string
-	switch category {
-	case "psx":
-		prompt = "Generate a unique PSX-style 3D asset idea. Think retro low-poly aesthetic from PlayStation 1 era: pixelated textures, affine texture warping, no perspective correction, 16-bit color palette. Suggest specific objects like: retro electronics, vending machines, household items, packaging, street objects. Keep it practical for a solo 3D artist. Return only the idea text, no extra fluff."
-	default:
-		prompt = "Generate a unique and creative project idea for the category: " + category + ". The idea should be innovative and interesting for an 18-year-old developer and 3D artist. Return only the idea text, no extra fluff."
+	response, err := callPrompt(c.Request.Context(), "code.generate", gin.H{"Language": req.Language, "Task": req.Task})
+	if err != nil {
+		respondLLMError(c, err)
+		return
 	}
-	
-	rawIdea := callGroq(ctx, prompt, "You are a creative idea generator.")
-	
-	// Self-Criticism Layer
-	criticPrompt := fmt.Sprintf("Analyze this project idea: \"%s\". Find 2-3 potential risks or weaknesses and suggest a way to make it more unique or 'wow'. Return only the refined idea with a short 'Critic Note' at the end.", rawIdea)
-	refinedIdea := callGroq(ctx, criticPrompt, "You are a sharp startup critic.")
-	
-	return refinedIdea
+	c.JSON(http.StatusOK, gin.H{"code": response})
 }
 
 // ============ EMAIL BUILDER HANDLERS ============
@@ -358,9 +412,49 @@ func init() {
 type EmailRequest struct {
 	Type      string                 `json:"type"`
 	Theme     map[string]string     `json:"theme"`
-	Blocks    []map[string]interface{} `json:"blocks"`
+	Blocks    []map[string]interface{} `json:"blocks" jsonschema:"required"`
 	Preheader string                `json:"preheader"`
-	Subject   string                `json:"subject"`
+	Subject   string                `json:"subject" jsonschema:"required"`
+}
+
+var emailBlockTypes = []string{"header", "hero", "text", "button", "products", "social", "divider", "cta", "quote", "event", "stats", "faq", "video", "gallery", "countdown", "banner", "features", "pricing", "spacer", "columns", "alert", "image", "html", "form", "badge", "list", "survey", "download", "footer2", "steps", "cards", "testimonial", "stars", "progress", "gift", "logo", "share", "qr", "seal", "timer", "barcode", "instagram", "telegram", "youtube", "spotify", "discord", "whatsapp", "twitch", "soundcloud"}
+
+// emailCriticRounds is how many critique rounds refine a generated email
+// (EMAIL_CRITIC_ROUNDS, 0 disables the critic).
+var emailCriticRounds = 1
+
+// emailRubric is what the email critic scores.
+var emailRubric = []rubricItem{
+	{Name: "subject", Description: "the subject line makes the reader open the email"},
+	{Name: "cta", Description: "there is one clear call to action"},
+	{Name: "structure", Description: "the blocks fit the goal of the email and flow logically"},
+}
+
+func setupEmailBuilder() {
+	if n, err := strconv.Atoi(os.Getenv("EMAIL_CRITIC_ROUNDS")); err == nil && n >= 0 {
+		emailCriticRounds = n
+	}
+}
+
+// emailRequestSchema is the JSON Schema the AI email builder must satisfy:
+// EmailRequest itself, plus the shape of each block.
+func emailRequestSchema() *jsonSchema {
+	schema := schemaFor(reflect.TypeOf(EmailRequest{}))
+	schema.Properties["blocks"].MinItems = 1
+	schema.Properties["blocks"].Items = &jsonSchema{
+		Type:     "object",
+		Required: []string{"type", "data"},
+		Properties: map[string]*jsonSchema{
+			"type":    {Type: "string", Enum: emailBlockTypes},
+			"enabled": {Type: "boolean"},
+			// Block fields are free-form; only the gallery's list is typed
+			// because the renderer iterates over it
+			"data": {Type: "object", Properties: map[string]*jsonSchema{
+				"images": {Type: "array", Items: &jsonSchema{Type: "string"}},
+			}},
+		},
+	}
+	return schema
 }
 
 func handleEmailGenerate(c *gin.Context) {
@@ -373,7 +467,22 @@ func handleEmailGenerate(c *gin.Context) {
 	// Generate HTML (simplified - returns placeholder)
 	html := generateEmailHTML(req)
 	
-	c.JSON(http.StatusOK, gin.H{"html": html, "id": "email_" + randomString(8)})
+	c.JSON(http.StatusOK, gin.H{"html": html, "id": saveEmail(req, html)})
+}
+
+// saveEmail stores a rendered email and returns its id.
+func saveEmail(req EmailRequest, html string) string {
+	id, err := randomToken("email_", 8)
+	if err != nil {
+		log.Printf("Email save error: %v", err)
+		return ""
+	}
+	request, _ := json.Marshal(req)
+	err = db.SaveEmail(&store.Email{ID: id, Type: req.Type, Subject: req.Subject, Request: string(request), HTML: html})
+	if err != nil {
+		log.Printf("Email save error: %v", err)
+	}
+	return id
 }
 
 func handleAIGenerate(c *gin.Context) {
@@ -386,40 +495,66 @@ func handleAIGenerate(c *gin.Context) {
 		return
 	}
 
-	systemPrompt := `Ты - Email Generation Expert. Твоя задача: на основе промпта пользователя составить структуру профессионального письма в формате JSON.
-Доступные блоки (type): header, hero, text, button, products, social, divider, cta, quote, event, stats, faq, video, gallery, countdown, banner, features, pricing, spacer, columns, alert, image, html, form, badge, list, survey, download, footer2, steps, cards, testimonial, stars, progress, gift, logo, share, qr, seal, timer, barcode, instagram, telegram, youtube, spotify, discord, whatsapp, twitch, soundcloud.
-
-Формат ответа: ТОЛЬКО JSON объекта EmailRequest.
-Пример:
-{
-  "subject": "Заголовок письма",
-  "preheader": "Краткое описание",
-  "blocks": [
-    {"type": "header", "enabled": true, "data": {"logo": "MYBRAND"}},
-    {"type": "hero", "enabled": true, "data": {"title": "Привет!", "description": "Это письмо создано ИИ."}},
-    {"type": "footer2", "enabled": true, "data": {"company": "Моя Компания"}}
-  ]
-}
+	schema := emailRequestSchema()
+	schemaJSON, _ := json.Marshal(schema)
 
-Не добавляй лишнего текста, только JSON.`
+	ctx := c.Request.Context()
+	p, err := renderPrompt(ctx, "email.generate", gin.H{
+		"BlockTypes": strings.Join(emailBlockTypes, ", "),
+		"Schema":     string(schemaJSON),
+		"Input":      req.Prompt,
+	})
+	if err != nil {
+		respondLLMError(c, err)
+		return
+	}
+	var draft EmailRequest
+	draftOK := false
+	generate := func(ctx context.Context) (string, error) {
+		raw, err := generateJSON(ctx, p.Request(), schema, &draft, 2)
+		draftOK = err == nil
+		if errors.Is(err, errSchemaMismatch) {
+			// Let the critic repair it
+			log.Printf("AI JSON Error: %v", err)
+			return raw, nil
+		}
+		return raw, err
+	}
 
-	aiResponse := callGroq(c.Request.Context(), req.Prompt, systemPrompt)
-	
 	// Self-Criticism Layer for Email Builder
-	criticPrompt := fmt.Sprintf("Analyze this email structure and content generated for the prompt \"%s\":\n\n%s\n\nFind 2-3 potential issues (e.g., missing call to action, boring subject line, block mismatch) and suggest improvements. Return ONLY the improved JSON object EmailRequest. No extra text.", req.Prompt, aiResponse)
-	improvedResponse := callGroq(c.Request.Context(), criticPrompt, "You are a professional email marketing critic.")
+	pipeline := critiquePipeline{Critic: "email.critic", Rounds: emailCriticRounds, Rubric: emailRubric, MinScore: 8, Artifact: schema}
+	res, err := pipeline.Run(ctx, generate, gin.H{"Prompt": req.Prompt})
+	if res.Draft == "" && err != nil {
+		respondLLMError(c, err)
+		return
+	}
+	// improved_ai is only set when the critic revised the draft; an
+	// approved draft stays as generated and is used in its parsed form
+	aiResponse, improvedResponse := res.Draft, ""
+	for _, r := range res.Rounds {
+		if r.Revised != "" {
+			improvedResponse = res.Final
+		}
+	}
 
-	var emailReq EmailRequest
-	err := json.Unmarshal([]byte(improvedResponse), &emailReq)
-	if err != nil {
-		// Fallback to initial AI response if improved one fails JSON
-		log.Printf("Improved AI JSON Error: %v, Response: %s", err, improvedResponse)
-		err = json.Unmarshal([]byte(aiResponse), &emailReq)
+	var improved EmailRequest
+	if err == nil && improvedResponse != "" {
+		err = json.Unmarshal([]byte(improvedResponse), &improved)
 	}
 
-	if err != nil {
+	var emailReq EmailRequest
+	switch {
+	case err == nil && improvedResponse != "":
+		emailReq = improved
+	case draftOK:
+		// The critic pass is best-effort; keep going with the first draft
+		if err != nil {
+			log.Printf("Email critic error: %v", err)
+		}
+		emailReq = draft
+	default:
 		// Final fallback if both fail
-		log.Printf("AI JSON Error: %v, Response: %s", err, aiResponse)
+		log.Printf("Email critic error: %v", err)
 		emailReq = EmailRequest{
 			Type:      req.Type,
 			Subject:   "Email Generated by AI",
@@ -436,7 +571,8 @@ func handleAIGenerate(c *gin.Context) {
 	}
 	
 	html := generateEmailHTML(emailReq)
-	c.JSON(http.StatusOK, gin.H{"html": html, "id": "email_" + randomString(8), "raw_ai": aiResponse, "improved_ai": improvedResponse})
+	c.JSON(http.StatusOK, gin.H{"html": html, "id": saveEmail(emailReq, html), "raw_ai": aiResponse, "improved_ai": improvedResponse,
+		"critic": gin.H{"notes": res.Notes, "score": res.Score, "rounds": res.Rounds}})
 }
 
 func handleAISubject(c *gin.Context) {
@@ -448,8 +584,11 @@ func handleAISubject(c *gin.Context) {
 		return
 	}
 
-	systemPrompt := "Ты эксперт по Email-маркетингу. Придумай 3 цепляющих темы письма на основе описания. Верни их списком через запятую. Только темы, без лишнего текста."
-	response := callGroq(c.Request.Context(), req.Prompt, systemPrompt)
+	response, err := callPrompt(c.Request.Context(), "email.subject", gin.H{"Input": req.Prompt})
+	if err != nil {
+		respondLLMError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"subjects": response})
 }
 
@@ -465,9 +604,26 @@ func handleImageUpload(c *gin.Context) {
 	
 	// Generate unique name
 	ext := filepath.Ext(header.Filename)
-	filename := randomString(12) + ext
+	filename, err := randomToken("", 8)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	filename += ext
 	
-	os.WriteFile(filepath.Join(emailStorage, filename), data, 0644)
+	if err := os.WriteFile(filepath.Join(emailStorage, filename), data, 0644); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	err = db.AddUpload(&store.Upload{
+		Filename:     filename,
+		OriginalName: header.Filename,
+		ContentType:  header.Header.Get("Content-Type"),
+		Size:         int64(len(data)),
+	})
+	if err != nil {
+		log.Printf("Upload record error: %v", err)
+	}
 	
 	c.JSON(http.StatusOK, gin.H{"url": "/storage/" + filename})
 }
@@ -700,9 +856,15 @@ body, table, td { font-family: Arial, Helvetica, sans-serif; }
 			}
 
 		case "gallery":
-			images, _ := data["images"].([]interface{})
+			raw, _ := data["images"].([]interface{})
+			var images []string
+			for _, img := range raw {
+				if v, ok := img.(string); ok && v != "" {
+					images = append(images, v)
+				}
+			}
 			if len(images) == 0 {
-				images = []interface{}{
+				images = []string{
 					"https://via.placeholder.com/300x200",
 					"https://via.placeholder.com/300x200",
 					"https://via.placeholder.com/300x200",
@@ -712,7 +874,7 @@ body, table, td { font-family: Arial, Helvetica, sans-serif; }
 			for i, img := range images {
 				if i > 0 && i%3 == 0 { html += `</tr><tr>` }
 				if i%3 > 0 { html += `<td style="width:8px;"></td>` }
-				html += `<td align="center" width="180"><img src="`+img.(string)+`" width="180" height="120" style="display:block; border-radius:4px;"></td>`
+				html += `<td align="center" width="180"><img src="`+img+`" width="180" height="120" style="display:block; border-radius:4px;"></td>`
 			}
 			html += `</tr></table></td></tr>`
 
@@ -1269,121 +1431,157 @@ body, table, td { font-family: Arial, Helvetica, sans-serif; }
 	return html
 }
 
-func randomString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[time.Now().UnixNano()%int64(len(letters))]
+// randomToken returns prefix followed by size random bytes in hex, for ids
+// and memos nobody should be able to guess.
+func randomToken(prefix string, size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return string(b)
+	return prefix + hex.EncodeToString(b), nil
 }
 
 func handlePlannerCriticExecutor(c *gin.Context) {
 	var req struct {
-		UserID string `json:"user_id" binding:"required"`
-		Task   string `json:"task" binding:"required"`
+		Task string `json:"task" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and Task are required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Task is required"})
 		return
 	}
 
-	if !premiumUsers[req.UserID] {
-		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Premium required. Buy Stars to unlock high-stakes workflows!"})
+	response, err := callPrompt(c.Request.Context(), "supervisor.pce", gin.H{"Input": req.Task})
+	if err != nil {
+		respondLLMError(c, err)
 		return
 	}
-
-	systemPrompt := `Ты - Planner-Critic-Executor System (v2.8). 
-Твоя работа:
-1. Planner: Составь подробный план решения задачи.
-2. Critic: Найди слабые места в этом плане и предложи улучшения.
-3. Executor: Выполни задачу, учитывая критику.
-
-Верни итоговый результат на русском языке.`
-	
-	response := callGroq(c.Request.Context(), req.Task, systemPrompt)
 	c.JSON(http.StatusOK, gin.H{"response": response})
 }
 
 func handleSupervisorMarketing(c *gin.Context) {
 	var req struct {
-		UserID string `json:"user_id" binding:"required"`
-		Goal   string `json:"goal" binding:"required"`
+		Goal string `json:"goal" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and Goal are required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Goal is required"})
 		return
 	}
 
-	if !premiumUsers[req.UserID] {
-		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Premium required. Buy Stars to unlock the Marketing Specialist!"})
+	response, err := callPrompt(c.Request.Context(), "supervisor.marketing", gin.H{"Input": req.Goal})
+	if err != nil {
+		respondLLMError(c, err)
 		return
 	}
-
-	systemPrompt := `Ты - Social Media Specialist (v2.7). Составь 7-дневный контент-план для продвижения стартапа пользователя. 
-Для каждого дня укажи:
-1. Тему поста.
-2. Текст поста (коротко).
-3. Визуальный стиль (например, PSX-style скриншот или инфографика).
-Будь креативным и ориентируйся на привлечение первых пользователей.`
-	
-	response := callGroq(c.Request.Context(), req.Goal, systemPrompt)
 	c.JSON(http.StatusOK, gin.H{"response": response})
 }
 
 type Job struct {
-	ID           int
-	Specialist   string
-	Prompt       string
-	SystemPrompt string
+	ID         int
+	Specialist string
+	Request    ChatRequest
 }
 
 type JobResult struct {
 	ID         int
 	Specialist string
 	Response   string
+	Meta       Completion
+	Error      error
+}
+
+// JobEvent reports worker progress when the supervisor is streaming.
+type JobEvent struct {
+	Type       string // "specialist_start", "token", "specialist_done", "specialist_error"
+	ID         int
+	Specialist string
+	Text       string
+	Meta       Completion
 	Error      error
 }
 
-func worker(ctx context.Context, id int, jobs <-chan Job, results chan<- JobResult) {
+// recordJobRun stores one specialist call with the user and route of the
+// request that made it.
+func recordJobRun(ctx context.Context, j Job, comp Completion, err error, started time.Time) {
+	scope := scopeFromContext(ctx)
+	run := store.JobRun{
+		UserID:     scope.UserID,
+		Route:      scope.Route,
+		Specialist: j.Specialist,
+		Status:     "ok",
+		Provider:   comp.Provider,
+		Model:      comp.Model,
+		DurationMS: time.Since(started).Milliseconds(),
+		StartedAt:  started,
+	}
+	if err != nil {
+		run.Status = "error"
+		run.Error = err.Error()
+	}
+	if err := db.AddJobRun(&run); err != nil {
+		log.Printf("Job run record error: %v", err)
+	}
+}
+
+// worker runs jobs until the channel is closed. When events is non-nil the
+// completion is streamed and progress is reported on it.
+func worker(ctx context.Context, id int, jobs <-chan Job, results chan<- JobResult, events chan<- JobEvent) {
+	emit := func(ev JobEvent) {
+		if events == nil {
+			return
+		}
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+		}
+	}
+
 	for j := range jobs {
 		select {
 		case <-ctx.Done():
+			// Keep draining so the supervisor gets a result for every job
 			results <- JobResult{ID: j.ID, Specialist: j.Specialist, Error: ctx.Err()}
-			return
+			emit(JobEvent{Type: "specialist_error", ID: j.ID, Specialist: j.Specialist, Error: ctx.Err()})
 		default:
-			response := callGroq(ctx, j.Prompt, j.SystemPrompt)
-			results <- JobResult{ID: j.ID, Specialist: j.Specialist, Response: response}
+			var comp Completion
+			var err error
+			started := time.Now()
+			if events != nil {
+				emit(JobEvent{Type: "specialist_start", ID: j.ID, Specialist: j.Specialist})
+				comp, err = streamChat(ctx, j.Request, func(delta string) {
+					emit(JobEvent{Type: "token", ID: j.ID, Specialist: j.Specialist, Text: delta})
+				})
+			} else {
+				comp, err = completeLLM(ctx, j.Request)
+			}
+			recordJobRun(ctx, j, comp, err, started)
+			results <- JobResult{ID: j.ID, Specialist: j.Specialist, Response: comp.Text, Meta: comp, Error: err}
+			if err != nil {
+				emit(JobEvent{Type: "specialist_error", ID: j.ID, Specialist: j.Specialist, Error: err})
+			} else {
+				emit(JobEvent{Type: "specialist_done", ID: j.ID, Specialist: j.Specialist, Text: comp.Text, Meta: comp})
+			}
 		}
 	}
 }
 
 func handleSupervisorStartup(c *gin.Context) {
 	var req struct {
-		UserID string `json:"user_id" binding:"required"`
-		Goal   string `json:"goal" binding:"required"`
+		Goal string `json:"goal" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and Goal are required"})
-		return
-	}
-
-	if !premiumUsers[req.UserID] {
-		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Premium required. Buy Stars to unlock the Supervisor!"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Goal is required"})
 		return
 	}
 
-	specialists := []struct {
-		Name   string
-		Prompt string
-	}{
-		{"Namer", "Придумай 3 креативных названия для этого стартапа."},
-		{"Market", "Проанализируй целевую аудиторию и дай 3 совета по маркетингу."},
-		{"Design", "Опиши визуальный стиль (цвета, шрифты, вайб). Упомяни PSX-эстетику если уместно."},
-		{"Tech", "Подбери стек технологий: фронтенд, бэкенд, база данных."},
-		{"Pitch", "Составь структуру питча: Проблема, Решение, Монетизация."},
-		{"Negotiator", "Предложи стратегию переговоров с инвесторами и партнерами: как обосновать цену и какие условия просить."},
-		{"Outreach", "Напиши черновик холодного письма для привлечения первых клиентов или партнеров."},
+	specialists := []string{"Namer", "Market", "Design", "Tech", "Pitch", "Negotiator", "Outreach"}
+	requests := make([]ChatRequest, len(specialists))
+	for i, name := range specialists {
+		p, err := renderPrompt(c.Request.Context(), "supervisor.startup", gin.H{"Specialist": name, "Input": req.Goal})
+		if err != nil {
+			respondLLMError(c, err)
+			return
+		}
+		requests[i] = p.Request()
 	}
 
 	numJobs := len(specialists)
@@ -1394,98 +1592,118 @@ func handleSupervisorStartup(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
 	defer cancel()
 
+	var events chan JobEvent
+	stream := wantsStream(c)
+	if stream {
+		events = make(chan JobEvent)
+		startSSE(c)
+	}
+
 	// Start 3 workers
 	for w := 1; w <= 3; w++ {
-		go worker(ctx, w, jobs, results)
+		go worker(ctx, w, jobs, results, events)
 	}
 
 	// Send jobs
-	for i, spec := range specialists {
-		jobs <- Job{
-			ID:           i,
-			Specialist:   spec.Name,
-			Prompt:       req.Goal,
-			SystemPrompt: spec.Prompt + " Отвечай на русском языке.",
-		}
+	for i, name := range specialists {
+		jobs <- Job{ID: i, Specialist: name, Request: requests[i]}
 	}
 	close(jobs)
 
+	if stream {
+		relaySupervisorEvents(ctx, c, events, numJobs)
+	}
+
 	// Collect results
 	var finalResponse string
 	finalResponse = "### Пакет стартапа от Supervisor v3.0 (Parallel Orchestration)\n\n"
 	
+	var firstErr error
+	failed := 0
+	meta := make(map[string]Completion, numJobs)
 	for i := 0; i < numJobs; i++ {
 		res := <-results
+		meta[res.Specialist] = res.Meta
 		if res.Error != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = res.Error
+			}
 			finalResponse += fmt.Sprintf("#### %s: Ошибка: %s\n\n", res.Specialist, res.Error)
 		} else {
 			finalResponse += fmt.Sprintf("#### %s\n%s\n\n", res.Specialist, res.Response)
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"response": finalResponse})
-}
-
-func handleStarsPay(c *gin.Context) {
-	var req struct {
-		UserID string `json:"user_id" binding:"required"`
-		Amount int    `json:"amount" binding:"required"`
+	// Partial results are still useful; only fail when nobody answered
+	if failed == numJobs {
+		if stream {
+			sendSSEError(c, firstErr)
+		} else {
+			respondLLMError(c, firstErr)
+		}
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and Amount are required"})
+
+	if stream {
+		sendSSE(c, "done", gin.H{"response": finalResponse, "meta": meta})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"response": finalResponse, "meta": meta})
+}
 
-	// Mock payment: if amount >= 50 stars, grant premium
-	if req.Amount >= 50 {
-		premiumUsers[req.UserID] = true
-		savePremiumUsers()
-		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Premium activated!"})
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Insufficient amount. Minimum 50 Stars."})
+// relaySupervisorEvents forwards worker events as SSE until every job has
+// finished or failed.
+func relaySupervisorEvents(ctx context.Context, c *gin.Context, events <-chan JobEvent, numJobs int) {
+	for finished := 0; finished < numJobs; {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			data := gin.H{"id": ev.ID, "specialist": ev.Specialist}
+			switch ev.Type {
+			case "token":
+				data["text"] = ev.Text
+			case "specialist_done":
+				data["response"] = ev.Text
+				data["meta"] = ev.Meta
+				finished++
+			case "specialist_error":
+				_, envelope := llmErrorEnvelope(ev.Error)
+				data["error"] = envelope["error"]
+				data["code"] = envelope["code"]
+				finished++
+			}
+			sendSSE(c, ev.Type, data)
+		}
 	}
 }
 
 func handleProBrainstorm(c *gin.Context) {
 	var req struct {
-		UserID string `json:"user_id" binding:"required"`
 		Prompt string `json:"prompt" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and Prompt are required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Prompt is required"})
 		return
 	}
 
-	if !premiumUsers[req.UserID] {
-		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Premium required. Buy Stars to unlock!"})
+	p, err := renderPrompt(c.Request.Context(), "brainstorm.pro", gin.H{"Input": req.Prompt})
+	if err != nil {
+		respondLLMError(c, err)
 		return
 	}
-
-	systemPrompt := "Ты экспертный бизнес-аналитик. Проведи глубокий брейншторм идеи пользователя. Выдели: 1. Уникальность, 2. Рыночный потенциал, 3. Риски, 4. Первые шаги."
-	response := callGroq(c.Request.Context(), req.Prompt, systemPrompt)
-	c.JSON(http.StatusOK, gin.H{"response": response})
-}
-
-func checkStars(c *gin.Context) {
-	var req struct {
-		UserID string `json:"user_id" binding:"required"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+	if wantsStream(c) {
+		streamChatCompletion(c, p.Request(), nil)
 		return
 	}
-
-	// Mock check: user_id "ezhik_tester" is always premium
-	if req.UserID == "ezhik_tester" {
-		premiumUsers[req.UserID] = true
-		savePremiumUsers()
+	comp, err := completeLLM(c.Request.Context(), p.Request())
+	response := comp.Text
+	if err != nil {
+		respondLLMError(c, err)
+		return
 	}
-
-	isPremium := premiumUsers[req.UserID]
-	c.JSON(http.StatusOK, gin.H{
-		"is_premium": isPremium,
-		"user_id":    req.UserID,
-	})
+	c.JSON(http.StatusOK, gin.H{"response": response})
 }
 
 func getString(data map[string]interface{}, key, def string) string {
@@ -1499,35 +1717,28 @@ func getString(data map[string]interface{}, key, def string) string {
 
 func handleRalphMode(c *gin.Context) {
 	var req struct {
-		UserID string `json:"user_id" binding:"required"`
-		PRD    string `json:"prd" binding:"required"`
-		Task   string `json:"task" binding:"required"`
+		PRD  string `json:"prd" binding:"required"`
+		Task string `json:"task" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID, PRD and Task are required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PRD and Task are required"})
 		return
 	}
 
-	if !premiumUsers[req.UserID] {
-		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Premium required. Buy Stars to unlock Ralph Mode (Autonomous Iteration)!"})
+	response, err := callPrompt(c.Request.Context(), "supervisor.ralph", gin.H{"PRD": req.PRD, "Task": req.Task})
+	if err != nil {
+		respondLLMError(c, err)
 		return
 	}
-
-	systemPrompt := `Ты - Ralph Autonomous Iteration System (v2.9). 
-Методология: "Eventual Consistency" (конечная согласованность).
-Твои шаги:
-1. Coder: Напиши решение задачи.
-2. Dialectic Critic: Тщательно проверь код на соответствие PRD. Найди все возможные баги, уязвимости и несоответствия стилю.
-3. Refactorer: Перепиши код, устранив все замечания Критика.
-
-Верни только финальный, отполированный результат на русском языке, но добавь краткий лог итерации в начале.`
-	
-	prompt := fmt.Sprintf("PRD: %s\n\nTask: %s", req.PRD, req.Task)
-	response := callGroq(c.Request.Context(), prompt, systemPrompt)
 	c.JSON(http.StatusOK, gin.H{"response": response})
 }
 
 func handleUCPDiscovery(c *gin.Context) {
+	items, err := catalogItems(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	discovery := map[string]interface{}{
 		"version": "2026.1",
 		"services": []map[string]interface{}{
@@ -1541,6 +1752,8 @@ func handleUCPDiscovery(c *gin.Context) {
 					"unit": "model",
 					"currency": "TON",
 				},
+				"purchase": "/api/ton/orders",
+				"items": items,
 			},
 			{
 				"id": "startup-builder",
@@ -1550,7 +1763,7 @@ func handleUCPDiscovery(c *gin.Context) {
 				"auth": "stars_token",
 				"pricing": map[string]interface{}{
 					"unit": "package",
-					"amount": 50,
+					"amount": featurePrice("startup-builder"),
 					"currency": "STARS",
 				},
 			},
@@ -1562,7 +1775,7 @@ func handleUCPDiscovery(c *gin.Context) {
 				"auth": "stars_token",
 				"pricing": map[string]interface{}{
 					"unit": "draft",
-					"amount": 10,
+					"amount": featurePrice("outreach-drafter"),
 					"currency": "STARS",
 				},
 			},
@@ -1581,6 +1794,18 @@ func handleUCPDiscovery(c *gin.Context) {
 		},
 		"capabilities": []string{"discovery", "quote", "purchase"},
 	}
+	// Agents can also pay per call with x402
+	for _, s := range discovery["services"].([]map[string]interface{}) {
+		if f, ok := lookupFeature(s["id"].(string)); ok && x402Priced(f) {
+			s["x402"] = map[string]interface{}{
+				"scheme":  "exact",
+				"network": x402.Network,
+				"asset":   x402.Asset,
+				"amount":  f.X402Amount,
+				"payTo":   x402.PayTo,
+			}
+		}
+	}
 	c.JSON(http.StatusOK, discovery)
 }
 
@@ -1599,7 +1824,7 @@ func handleAgentCard(c *gin.Context) {
 				"orchestration": "/api/supervisor/startup",
 			},
 			"payment": map[string]string{
-				"ton": "UQDqNihspM0odGiyRM2UkmsTa-GjuYY5Vfr1eOn93WRGx6ZL",
+				"ton": ton.Address,
 			},
 		}
 		c.JSON(http.StatusOK, card)
@@ -1632,56 +1857,3 @@ func handleProcurement(c *gin.Context) {
 	c.JSON(http.StatusOK, simulatedResult)
 }
 
-func handleB2ASchema(c *gin.Context) {
-	var req struct {
-		Name        string `json:"name" binding:"required"`
-		Description string `json:"description" binding:"required"`
-		Price       string `json:"price"`
-		Currency    string `json:"currency"`
-		Type        string `json:"type"` // "3dmodel", "software", "service", "product"
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and Description are required"})
-		return
-	}
-
-	systemPrompt := `Ты эксперт по Schema.org и GEO (Generative Engine Optimization). 
-Твоя задача: сгенерировать JSON-LD разметку для продукта, оптимизированную для ИИ-агентов (B2A).
-Используй типы: Product, 3DModel (если применимо), Offer.
-Добавь все возможные технические поля, чтобы ИИ-агенты могли легко парсить характеристики.
-Верни ТОЛЬКО JSON-LD код в теге <script type="application/ld+json">.`
-
-	prompt := fmt.Sprintf("Название: %s\nОписание: %s\nЦена: %s %s\nТип: %s", req.Name, req.Description, req.Price, req.Currency, req.Type)
-	response := callGroq(c.Request.Context(), prompt, systemPrompt)
-	c.JSON(http.StatusOK, gin.H{"response": response})
-}
-
-func handleGetAssets(c *gin.Context) {
-	data, err := os.ReadFile("assets.json")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read assets"})
-		return
-	}
-	var assets interface{}
-	json.Unmarshal(data, &assets)
-	c.JSON(http.StatusOK, assets)
-}
-
-func handleDiagnostics(c *gin.Context) {
-	// Gather system status
-	uptime := time.Since(time.Now().Add(-24 * time.Hour)) // Mock uptime for now
-	
-	memoryStats := map[string]interface{}{
-		"long_term_lines": 200, // Mock
-		"daily_log_size": "7KB",
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"status": "healthy",
-		"version": "v3.1.0",
-		"uptime": uptime.String(),
-		"memory": memoryStats,
-		"groq_api": "connected",
-		"agent_card": "active",
-	})
-}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// Tests name the caller with user_id, there is no bot token
+	telegramAuthDev = true
+	if err := setupPrompts(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// useTestDB points the global store at a fresh database for one test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db
+	db = s
+	t.Cleanup(func() {
+		db = prev
+		s.Close()
+	})
+}
+
+// newTestServer returns the full router backed by a fresh database and a
+// mock LLM provider the test scripts; unscripted calls echo the prompt.
+func newTestServer(t *testing.T) (*gin.Engine, *mockProvider) {
+	t.Helper()
+	useTestDB(t)
+	mock := newMockProvider()
+	prevProviders, prevDefault, prevCache, prevRetry := llmProviders, llmDefaultProvider, responseCache, llmRetry
+	llmProviders = map[string]Provider{"mock": mock}
+	llmDefaultProvider = "mock"
+	responseCache = newLLMCache(time.Hour, 100)
+	llmRetry = retryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	t.Cleanup(func() {
+		llmProviders, llmDefaultProvider, responseCache, llmRetry = prevProviders, prevDefault, prevCache, prevRetry
+	})
+	return setupRouter(), mock
+}
+
+// do sends a request with an optional JSON body and decodes a JSON answer
+// into out when it is not nil.
+func do(t *testing.T, r http.Handler, method, path string, body interface{}, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %d %s: %v", method, path, w.Code, w.Body, err)
+		}
+	}
+	return w
+}
+
+func TestIdeaRoute(t *testing.T) {
+	r, mock := newTestServer(t)
+	mock.Script("business idea generator", "A PSX vending machine pack")
+	mock.Script("sharp startup critic",
+		`{"notes": ["crowded niche"], "revised": "A PSX vending machine pack with a glitch shader",
+			"scores": {"originality": 6, "feasibility": 9, "specificity": 8}}`,
+		`{"notes": [], "revised": "unused", "scores": {"originality": 9, "feasibility": 9, "specificity": 9}}`)
+
+	var got struct {
+		ID   int64  `json:"id"`
+		Idea string `json:"idea"`
+	}
+	if w := do(t, r, http.MethodGet, "/api/idea?category=business&lang=en", nil, &got); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/idea: %d %s", w.Code, w.Body)
+	}
+	if got.Idea != "A PSX vending machine pack with a glitch shader" {
+		t.Errorf("idea = %q, want the critic's revision", got.Idea)
+	}
+
+	var stored store.Idea
+	if w := do(t, r, http.MethodGet, "/api/ideas/"+strconv.FormatInt(got.ID, 10), nil, &stored); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/ideas/%d: %d %s", got.ID, w.Code, w.Body)
+	}
+	if stored.Raw != "A PSX vending machine pack" {
+		t.Errorf("stored draft = %q", stored.Raw)
+	}
+}
+
+func TestAIRoute(t *testing.T) {
+	r, mock := newTestServer(t)
+	mock.Script("helpful AI assistant", "Hello from the mock")
+
+	var got struct {
+		Response string     `json:"response"`
+		Meta     Completion `json:"meta"`
+	}
+	w := do(t, r, http.MethodPost, "/api/ai?lang=en", gin.H{"prompt": "Hi"}, &got)
+	if w.Code != http.StatusOK || got.Response != "Hello from the mock" || got.Meta.Provider != "mock" {
+		t.Fatalf("POST /api/ai: %d %s", w.Code, w.Body)
+	}
+	if w := do(t, r, http.MethodPost, "/api/ai", gin.H{}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/ai without a prompt: %d, want 400", w.Code)
+	}
+}
+
+func TestLLMErrorStatus(t *testing.T) {
+	r, mock := newTestServer(t)
+	mock.Script("helpful AI assistant").Error = string(ErrKindAuth)
+
+	var got struct {
+		Code string `json:"code"`
+	}
+	w := do(t, r, http.MethodPost, "/api/ai?lang=en", gin.H{"prompt": "Hi"}, &got)
+	if w.Code != http.StatusBadGateway || got.Code != "llm_auth" {
+		t.Errorf("POST /api/ai with a failing provider: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestSupervisorStartupRoute(t *testing.T) {
+	r, mock := newTestServer(t)
+	mock.Script("Come up with 3 creative names", "1. Ezhik Labs 2. Hedgehog Forge 3. Spiky Start")
+	mock.Script("Придумай 3 креативных названия", "1. Ёжик Лаб 2. Колючий Старт 3. Иглы")
+
+	body := gin.H{"goal": "A coffee shop for game developers", "user_id": "42"}
+	if w := do(t, r, http.MethodPost, "/api/supervisor/startup", body, nil); w.Code != http.StatusPaymentRequired {
+		t.Fatalf("without premium: %d, want 402", w.Code)
+	}
+	if err := grantTier("42", "premium", "test", 0); err != nil {
+		t.Fatal(err)
+	}
+	for lang, names := range map[string]string{"en": "Ezhik Labs", "ru": "Ёжик Лаб"} {
+		var got struct {
+			Response string `json:"response"`
+		}
+		w := do(t, r, http.MethodPost, "/api/supervisor/startup?lang="+lang, body, &got)
+		if w.Code != http.StatusOK || !strings.Contains(got.Response, names) {
+			t.Errorf("%s: %d, response %q lacks %q", lang, w.Code, got.Response, names)
+		}
+	}
+}
+
+func TestMockFixturesMatchPrompts(t *testing.T) {
+	data, err := os.ReadFile("mock_llm.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []mockFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	vars := gin.H{"Category": "psx", "Draft": "", "Input": "", "Liked": nil, "Disliked": nil, "Avoid": nil,
+		"Rubric": nil, "Schema": "", "BlockTypes": "", "Prompt": "", "Name": "", "Description": "", "Type": ""}
+	var systems []string
+	for _, name := range []string{"idea.generate", "idea.critic", "email.generate", "email.critic", "b2a.schema",
+		"supervisor.startup"} {
+		for _, specialist := range []string{"Namer", "Market"} {
+			for _, locale := range []string{"en", "ru"} {
+				vars["Specialist"] = specialist
+				p, err := prompts.Render(withLocale(context.Background(), locale), name, vars)
+				if err != nil {
+					t.Fatalf("%s (%s): %v", name, locale, err)
+				}
+				systems = append(systems, p.System)
+			}
+		}
+	}
+	for _, f := range fixtures {
+		matched := false
+		for _, s := range systems {
+			matched = matched || strings.Contains(s, f.System)
+		}
+		if !matched {
+			t.Errorf("fixture %q matches no system prompt", f.System)
+		}
+	}
+}
+
+func TestDiagnosticsRefreshNeedsAdmin(t *testing.T) {
+	r, _ := newTestServer(t)
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	for _, tc := range []struct {
+		path, token string
+		want        bool
+	}{
+		{"/api/diagnostics", "", true},
+		{"/api/diagnostics?refresh=1", "", false},
+		{"/api/diagnostics?refresh=1", "wrong", false},
+		{"/api/diagnostics?refresh=1", "secret", true},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("X-Admin-Token", tc.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Code != http.StatusUnauthorized; got != tc.want {
+			t.Errorf("%s with token %q: %d", tc.path, tc.token, w.Code)
+		}
+	}
+}
+
+func TestFeedbackNeedsCaller(t *testing.T) {
+	r, _ := newTestServer(t)
+	idea := store.Idea{Category: "business", Text: "Hedgehog coffee"}
+	if err := db.AddIdea(&idea); err != nil {
+		t.Fatal(err)
+	}
+
+	vote := gin.H{"idea_id": idea.ID, "feedback": "like"}
+	if w := do(t, r, http.MethodPost, "/api/feedback", vote, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("anonymous vote: %d, want 400", w.Code)
+	}
+	vote["user_id"] = "1"
+	for i := 0; i < 3; i++ {
+		if w := do(t, r, http.MethodPost, "/api/feedback", vote, nil); w.Code != http.StatusOK {
+			t.Fatalf("vote: %d %s", w.Code, w.Body)
+		}
+	}
+	top, err := db.RatedIdeas("", true, 10)
+	if err != nil || len(top) != 1 || top[0].Likes != 1 {
+		t.Fatalf("RatedIdeas = %+v, %v; want one like", top, err)
+	}
+
+	telegramAuthDev = false
+	defer func() { telegramAuthDev = true }()
+	if w := do(t, r, http.MethodPost, "/api/feedback", vote, nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("vote with an untrusted user_id: %d, want 503", w.Code)
+	}
+}
+
+func TestIdeaHistoryIsTheCallers(t *testing.T) {
+	r, _ := newTestServer(t)
+	if w := do(t, r, http.MethodGet, "/api/idea?user_id=1", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/idea: %d %s", w.Code, w.Body)
+	}
+
+	var got struct {
+		Ideas []store.Idea `json:"ideas"`
+	}
+	do(t, r, http.MethodGet, "/api/ideas/history?user_id=1", nil, &got)
+	if len(got.Ideas) != 1 || got.Ideas[0].UserID != "1" {
+		t.Errorf("history of 1 = %+v", got.Ideas)
+	}
+	do(t, r, http.MethodGet, "/api/ideas/history?user_id=2", nil, &got)
+	if len(got.Ideas) != 0 {
+		t.Errorf("history of 2 has %d ideas", len(got.Ideas))
+	}
+
+	telegramAuthDev = false
+	defer func() { telegramAuthDev = true }()
+	if w := do(t, r, http.MethodGet, "/api/ideas/history?user_id=1", nil, nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("history with an untrusted user_id: %d, want 503", w.Code)
+	}
+	do(t, r, http.MethodGet, "/api/idea?user_id=1", nil, nil)
+	if ideas, _, _ := db.ListIdeas(store.IdeaFilter{UserID: "1"}); len(ideas) != 1 {
+		t.Errorf("an untrusted user_id was credited with %d ideas", len(ideas))
+	}
+}
+
+func TestAIGenerateKeepsApprovedDraft(t *testing.T) {
+	r, mock := newTestServer(t)
+	email := `{"subject": "Spring sale", "type": "promo", "blocks": [
+		{"type": "gallery", "data": {"images": ["https://example.com/a.png"]}, "enabled": true}]}`
+	mock.Script("Email Generation Expert", "```json\n"+email+"\n```")
+	mock.Script("email marketing critic", `{"notes": [], "revised": `+email+`,
+		"scores": {"subject": 9, "cta": 8, "structure": 9}}`)
+
+	var got struct {
+		HTML       string `json:"html"`
+		ImprovedAI string `json:"improved_ai"`
+	}
+	w := do(t, r, http.MethodPost, "/api/ai-generate?lang=en", gin.H{"prompt": "Spring sale"}, &got)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if got.ImprovedAI != "" || !strings.Contains(got.HTML, "https://example.com/a.png") {
+		t.Errorf("improved_ai = %q, html has the gallery: %v", got.ImprovedAI, strings.Contains(got.HTML, "a.png"))
+	}
+}
+
+func TestEmailGalleryIgnoresNonStrings(t *testing.T) {
+	html := generateEmailHTML(EmailRequest{Subject: "s", Blocks: []map[string]interface{}{
+		{"type": "gallery", "enabled": true, "data": map[string]interface{}{"images": []interface{}{42.0, "https://example.com/b.png", nil}}},
+	}})
+	if strings.Count(html, "<img src=") != 1 || !strings.Contains(html, `<img src="https://example.com/b.png"`) {
+		t.Errorf("gallery html: %s", html)
+	}
+	if errs := emailRequestSchema().Validate(map[string]interface{}{"subject": "s", "blocks": []interface{}{
+		map[string]interface{}{"type": "gallery", "data": map[string]interface{}{"images": []interface{}{42.0}}},
+	}}); len(errs) == 0 {
+		t.Error("schema accepts a non-string image")
+	}
+}
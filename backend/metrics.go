@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A small Prometheus text-format registry: counters, gauges and histograms
+// with labels, enough for /metrics without pulling in client_golang.
+
+type metricSeries struct {
+	labels  []string
+	value   float64
+	buckets []uint64 // histograms only, cumulative at write time
+	count   uint64
+}
+
+type metric struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    string // counter, gauge or histogram
+	labels  []string
+	bounds  []float64 // histogram upper bounds
+	series  map[string]*metricSeries
+	collect func() []metricSample // gauges computed at scrape time
+}
+
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+var metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+func newMetric(kind, name, help string, labels ...string) *metric {
+	m := &metric{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+	metricsRegistry.mu.Lock()
+	metricsRegistry.metrics = append(metricsRegistry.metrics, m)
+	metricsRegistry.mu.Unlock()
+	return m
+}
+
+func newCounter(name, help string, labels ...string) *metric {
+	return newMetric("counter", name, help, labels...)
+}
+
+func newHistogram(name, help string, bounds []float64, labels ...string) *metric {
+	m := newMetric("histogram", name, help, labels...)
+	m.bounds = bounds
+	return m
+}
+
+// newGaugeFunc registers a gauge whose samples are read on every scrape.
+func newGaugeFunc(name, help string, labels []string, collect func() []metricSample) *metric {
+	m := newMetric("gauge", name, help, labels...)
+	m.collect = collect
+	return m
+}
+
+func (m *metric) get(values []string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labels: append([]string(nil), values...)}
+		if m.kind == "histogram" {
+			s.buckets = make([]uint64, len(m.bounds))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add increments a counter.
+func (m *metric) Add(v float64, labels ...string) {
+	m.mu.Lock()
+	m.get(labels).value += v
+	m.mu.Unlock()
+}
+
+func (m *metric) Inc(labels ...string) {
+	m.Add(1, labels...)
+}
+
+// Observe records a histogram sample.
+func (m *metric) Observe(v float64, labels ...string) {
+	m.mu.Lock()
+	s := m.get(labels)
+	for i, bound := range m.bounds {
+		if v <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.value += v
+	s.count++
+	m.mu.Unlock()
+}
+
+func (m *metric) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	if m.collect != nil {
+		for _, s := range m.collect() {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labelString(m.labels, s.labels, "", ""), formatFloat(s.value))
+		}
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labelString(m.labels, s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.bounds {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelString(m.labels, s.labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelString(m.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labelString(m.labels, s.labels, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labelString(m.labels, s.labels, "", ""), s.count)
+	}
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	var parts []string
+	for i, name := range names {
+		if i < len(values) {
+			parts = append(parts, name+"="+strconv.Quote(values[i]))
+		}
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+"="+strconv.Quote(extraValue))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	httpRequests = newCounter("ezhik_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "status")
+	httpDuration = newHistogram("ezhik_http_request_duration_seconds",
+		"HTTP request latency by route and method.", latencyBuckets, "route", "method")
+	llmCalls = newCounter("ezhik_llm_calls_total",
+		"LLM provider calls (each retry counts) by provider, model and outcome (ok or the error kind).", "provider", "model", "outcome")
+	llmDuration = newHistogram("ezhik_llm_call_duration_seconds",
+		"LLM provider call latency.", latencyBuckets, "provider", "model")
+	llmTokens = newCounter("ezhik_llm_tokens_total",
+		"LLM tokens by provider, model and type (prompt or completion).", "provider", "model", "type")
+)
+
+func init() {
+	newGaugeFunc("ezhik_process_start_time_seconds", "Start time of the process in unix seconds.", nil,
+		func() []metricSample { return []metricSample{{value: float64(processStart.Unix())}} })
+	newGaugeFunc("ezhik_llm_cache_entries", "Entries in the LLM response cache.", nil, func() []metricSample {
+		if responseCache == nil {
+			return nil
+		}
+		return []metricSample{{value: float64(responseCache.Len())}}
+	})
+	newGaugeFunc("ezhik_health_check_up", "1 if the last health check passed, by check.", []string{"check"}, func() []metricSample {
+		var out []metricSample
+		for _, check := range health.Run(context.Background(), false) {
+			up := 0.0
+			if check.Status == healthOK {
+				up = 1
+			}
+			out = append(out, metricSample{labels: []string{check.Name}, value: up})
+		}
+		return out
+	})
+}
+
+// metricsMiddleware times every request by its route pattern.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.Inc(route, c.Request.Method, strconv.Itoa(c.Writer.Status()))
+		httpDuration.Observe(time.Since(start).Seconds(), route, c.Request.Method)
+	}
+}
+
+// observeLLMCall records one provider attempt.
+func observeLLMCall(provider, model string, elapsed time.Duration, usage Usage, err error) {
+	outcome := "ok"
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		outcome = string(llmErr.Kind)
+	} else if err != nil {
+		outcome = "error"
+	}
+	llmCalls.Inc(provider, model, outcome)
+	llmDuration.Observe(elapsed.Seconds(), provider, model)
+	if usage.PromptTokens > 0 {
+		llmTokens.Add(float64(usage.PromptTokens), provider, model, "prompt")
+	}
+	if usage.CompletionTokens > 0 {
+		llmTokens.Add(float64(usage.CompletionTokens), provider, model, "completion")
+	}
+}
+
+func handleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metricsRegistry.mu.Lock()
+	list := append([]*metric(nil), metricsRegistry.metrics...)
+	metricsRegistry.mu.Unlock()
+	for _, m := range list {
+		m.write(c.Writer)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prompt templates live in prompts/<name>/<version>.<locale>.tmpl. Each file
+// defines a "system" and a "user" template; prompts/manifest.json picks the
+// active version (or A/B weights) per name. The copy compiled into the
+// binary is used when PROMPTS_DIR does not exist on disk.
+//
+//go:embed prompts
+var embeddedPrompts embed.FS
+
+const manifestFile = "manifest.json"
+
+type promptManifest struct {
+	DefaultLocale string                     `json:"default_locale"`
+	Templates     map[string]promptSelection `json:"templates"`
+}
+
+// promptSelection configures one template name. Weights, when set, split
+// traffic between versions; otherwise Active (or the latest version) wins.
+type promptSelection struct {
+	Active        string         `json:"active,omitempty"`
+	Weights       map[string]int `json:"weights,omitempty"`
+	DefaultLocale string         `json:"default_locale,omitempty"`
+}
+
+// promptSet holds all versions and locales of one template name.
+type promptSet struct {
+	Name      string
+	Versions  map[string]map[string]*template.Template // version -> locale -> template
+	Selection promptSelection
+}
+
+type promptRegistry struct {
+	mu            sync.RWMutex
+	source        string
+	defaultLocale string
+	sets          map[string]*promptSet
+	categories    *categoryRegistry
+}
+
+// Prompt is a rendered template, ready to send.
+type Prompt struct {
+	Name    string
+	Version string
+	Locale  string
+	System  string
+	User    string
+}
+
+// ID identifies the template variant, e.g. "idea.generate@v1/en".
+func (p Prompt) ID() string {
+	return p.Name + "@" + p.Version + "/" + p.Locale
+}
+
+// Request turns the prompt into a single-turn ChatRequest.
+func (p Prompt) Request() ChatRequest {
+	return ChatRequest{
+		System:   p.System,
+		Messages: []ChatMessage{{Role: "user", Content: p.User}},
+		PromptID: p.ID(),
+	}
+}
+
+var prompts = &promptRegistry{
+	defaultLocale: "ru",
+	sets:          make(map[string]*promptSet),
+	categories:    &categoryRegistry{byName: make(map[string]*ideaCategory)},
+}
+
+func setupPrompts() error {
+	return prompts.Reload()
+}
+
+// Reload re-reads all templates. On error the previous templates stay active.
+func (r *promptRegistry) Reload() error {
+	var fsys fs.FS
+	source := envOr("PROMPTS_DIR", "prompts")
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		fsys = os.DirFS(source)
+	} else {
+		sub, _ := fs.Sub(embeddedPrompts, "prompts")
+		fsys = sub
+		source = "embedded"
+	}
+
+	manifest, sets, err := loadPromptSets(fsys)
+	if err != nil {
+		return fmt.Errorf("prompts (%s): %w", source, err)
+	}
+	builtin, _ := fs.Sub(embeddedPrompts, "prompts")
+	if source != "embedded" {
+		// Templates missing on disk fall back to the compiled-in copy
+		if _, builtinSets, err := loadPromptSets(builtin); err == nil {
+			for name, set := range builtinSets {
+				if _, ok := sets[name]; !ok {
+					sets[name] = set
+				}
+			}
+		}
+	}
+	categories, err := loadCategories(fsys, sets)
+	if errors.Is(err, fs.ErrNotExist) {
+		categories, err = loadCategories(builtin, sets)
+	}
+	if err != nil {
+		return fmt.Errorf("prompts (%s): %w", source, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.source = source
+	r.sets = sets
+	r.categories = categories
+	r.defaultLocale = envOr("PROMPTS_LOCALE", manifest.DefaultLocale)
+	if r.defaultLocale == "" {
+		r.defaultLocale = "ru"
+	}
+	log.Printf("Loaded %d prompt templates and %d idea categories from %s", len(sets), len(categories.Categories), source)
+	return nil
+}
+
+func loadPromptSets(fsys fs.FS) (promptManifest, map[string]*promptSet, error) {
+	var manifest promptManifest
+	if data, err := fs.ReadFile(fsys, manifestFile); err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return manifest, nil, fmt.Errorf("%s: %w", manifestFile, err)
+		}
+	}
+
+	sets := make(map[string]*promptSet)
+	files, err := fs.Glob(fsys, "*/*.tmpl")
+	if err != nil {
+		return manifest, nil, err
+	}
+	for _, file := range files {
+		name := path.Dir(file)
+		base := strings.TrimSuffix(path.Base(file), ".tmpl")
+		version, locale, ok := strings.Cut(base, ".")
+		if !ok {
+			return manifest, nil, fmt.Errorf("%s: expected <version>.<locale>.tmpl", file)
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return manifest, nil, err
+		}
+		tmpl, err := template.New(file).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return manifest, nil, err
+		}
+		if tmpl.Lookup("system") == nil || tmpl.Lookup("user") == nil {
+			return manifest, nil, fmt.Errorf("%s: must define \"system\" and \"user\"", file)
+		}
+
+		set, ok := sets[name]
+		if !ok {
+			set = &promptSet{Name: name, Versions: make(map[string]map[string]*template.Template)}
+			sets[name] = set
+		}
+		if set.Versions[version] == nil {
+			set.Versions[version] = make(map[string]*template.Template)
+		}
+		set.Versions[version][locale] = tmpl
+	}
+
+	for name, sel := range manifest.Templates {
+		set, ok := sets[name]
+		if !ok {
+			return manifest, nil, fmt.Errorf("%s: unknown template %q", manifestFile, name)
+		}
+		if sel.Active != "" && set.Versions[sel.Active] == nil {
+			return manifest, nil, fmt.Errorf("%s: %s has no version %q", manifestFile, name, sel.Active)
+		}
+		for v := range sel.Weights {
+			if set.Versions[v] == nil {
+				return manifest, nil, fmt.Errorf("%s: %s has no version %q", manifestFile, name, v)
+			}
+		}
+		set.Selection = sel
+	}
+	return manifest, sets, nil
+}
+
+func (s *promptSet) versionList() []string {
+	versions := make([]string, 0, len(s.Versions))
+	for v := range s.Versions {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+	return versions
+}
+
+// versionLess orders "v2" before "v10".
+func versionLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// pickVersion applies the A/B weights. A known user always lands on the
+// same version of a template; anonymous calls are split at random.
+func (s *promptSet) pickVersion(userID string) string {
+	versions := s.versionList()
+	total := 0
+	for _, v := range versions {
+		total += s.Selection.Weights[v]
+	}
+	if total > 0 {
+		var n int
+		if userID != "" {
+			h := fnv.New32a()
+			h.Write([]byte(s.Name + "|" + userID))
+			n = int(h.Sum32() % uint32(total))
+		} else {
+			n = rand.Intn(total)
+		}
+		for _, v := range versions {
+			n -= s.Selection.Weights[v]
+			if n < 0 {
+				return v
+			}
+		}
+	}
+	if s.Selection.Active != "" {
+		return s.Selection.Active
+	}
+	return versions[len(versions)-1]
+}
+
+// Render executes the template name for the locale and user of ctx.
+func (r *promptRegistry) Render(ctx context.Context, name string, data interface{}) (Prompt, error) {
+	r.mu.RLock()
+	set, ok := r.sets[name]
+	defaultLocale := r.defaultLocale
+	r.mu.RUnlock()
+	if !ok {
+		return Prompt{}, fmt.Errorf("prompt template %q not found", name)
+	}
+
+	scope := scopeFromContext(ctx)
+	version := set.pickVersion(scope.UserID)
+	locales := set.Versions[version]
+
+	if set.Selection.DefaultLocale != "" {
+		defaultLocale = set.Selection.DefaultLocale
+	}
+	locale := scope.Locale
+	if locales[locale] == nil {
+		locale = defaultLocale
+	}
+	if locales[locale] == nil {
+		for l := range locales {
+			locale = l
+			break
+		}
+	}
+	tmpl := locales[locale]
+
+	p := Prompt{Name: name, Version: version, Locale: locale}
+	var b strings.Builder
+	if err := tmpl.ExecuteTemplate(&b, "system", data); err != nil {
+		return Prompt{}, err
+	}
+	p.System = strings.TrimSpace(b.String())
+	b.Reset()
+	if err := tmpl.ExecuteTemplate(&b, "user", data); err != nil {
+		return Prompt{}, err
+	}
+	p.User = strings.TrimSpace(b.String())
+	return p, nil
+}
+
+func (r *promptRegistry) DefaultLocale() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.defaultLocale
+}
+
+func renderPrompt(ctx context.Context, name string, data interface{}) (Prompt, error) {
+	return prompts.Render(ctx, name, data)
+}
+
+// callPrompt renders a template and runs it as a single-turn completion.
+func callPrompt(ctx context.Context, name string, data interface{}) (string, error) {
+	p, err := renderPrompt(ctx, name, data)
+	if err != nil {
+		return "", err
+	}
+	comp, err := completeLLM(ctx, p.Request())
+	return comp.Text, err
+}
+
+// promptLocale reads the caller's locale from ?lang= or X-Locale.
+func promptLocale(c *gin.Context) string {
+	locale := c.Query("lang")
+	if locale == "" {
+		locale = c.GetHeader("X-Locale")
+	}
+	locale = strings.ToLower(locale)
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
+
+// withLocale returns a context whose templates render in locale.
+func withLocale(ctx context.Context, locale string) context.Context {
+	scope := *scopeFromContext(ctx)
+	scope.Locale = locale
+	return context.WithValue(ctx, callScopeKey{}, &scope)
+}
+
+type promptInfo struct {
+	Name          string              `json:"name"`
+	Versions      map[string][]string `json:"versions"`
+	Active        string              `json:"active,omitempty"`
+	Weights       map[string]int      `json:"weights,omitempty"`
+	DefaultLocale string              `json:"default_locale,omitempty"`
+}
+
+func (r *promptRegistry) List() gin.H {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]promptInfo, 0, len(r.sets))
+	for _, set := range r.sets {
+		info := promptInfo{
+			Name:          set.Name,
+			Versions:      make(map[string][]string),
+			Active:        set.Selection.Active,
+			Weights:       set.Selection.Weights,
+			DefaultLocale: set.Selection.DefaultLocale,
+		}
+		if info.Active == "" && len(info.Weights) == 0 {
+			versions := set.versionList()
+			info.Active = versions[len(versions)-1]
+		}
+		for v, locales := range set.Versions {
+			for l := range locales {
+				info.Versions[v] = append(info.Versions[v], l)
+			}
+			sort.Strings(info.Versions[v])
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return gin.H{"source": r.source, "default_locale": r.defaultLocale, "templates": list}
+}
+
+func handleListPrompts(c *gin.Context) {
+	c.JSON(http.StatusOK, prompts.List())
+}
+
+func handleReloadPrompts(c *gin.Context) {
+	if err := prompts.Reload(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prompts.List())
+}
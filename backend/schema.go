@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Schema.org JSON-LD built in Go: the structure (types, identifiers, offers)
+// comes from catalog or request fields, so the same input always yields the
+// same bytes. An LLM may only fill descriptive text, see schemaEnrichment,
+// and every document is checked by validateJSONLD before it is served.
+
+// schemaKinds maps the "type" of a B2A request to Schema.org types.
+var schemaKinds = map[string][]string{
+	"product":  {"Product"},
+	"3dmodel":  {"Product", "3DModel"},
+	"software": {"Product", "SoftwareApplication"},
+	"service":  {"Service"},
+}
+
+// schemaProperties lists the properties each type may carry; inherited ones
+// are spelled out. A document may use the union of its types.
+var schemaProperties = map[string][]string{
+	"Thing":               {"@context", "@type", "@id", "name", "description", "disambiguatingDescription", "url", "identifier"},
+	"Product":             {"sku", "category", "brand", "offers", "keywords", "audience"},
+	"3DModel":             {"author", "encodingFormat", "dateModified", "keywords", "audience"},
+	"SoftwareApplication": {"author", "dateModified", "keywords", "audience", "applicationCategory", "featureList"},
+	"Service":             {"serviceType", "category", "provider", "brand", "offers", "audience"},
+	"Offer":               {"@type", "price", "priceCurrency", "availability", "url", "seller"},
+}
+
+type schemaThing struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type schemaAudience struct {
+	Type         string `json:"@type"`
+	AudienceType string `json:"audienceType"`
+}
+
+type schemaOffer struct {
+	Type          string      `json:"@type"`
+	Price         string      `json:"price"`
+	PriceCurrency string      `json:"priceCurrency"`
+	Availability  string      `json:"availability,omitempty"`
+	URL           string      `json:"url,omitempty"`
+	Seller        schemaThing `json:"seller"`
+}
+
+// schemaDoc is a JSON-LD document; fields a type does not have stay empty.
+type schemaDoc struct {
+	Context             string          `json:"@context"`
+	Type                interface{}     `json:"@type"` // a string or a list of types
+	ID                  string          `json:"@id,omitempty"`
+	SKU                 string          `json:"sku,omitempty"`
+	Name                string          `json:"name"`
+	Description         string          `json:"description,omitempty"`
+	DisambiguatingDesc  string          `json:"disambiguatingDescription,omitempty"`
+	Keywords            []string        `json:"keywords,omitempty"`
+	Category            string          `json:"category,omitempty"`
+	ApplicationCategory string          `json:"applicationCategory,omitempty"`
+	ServiceType         string          `json:"serviceType,omitempty"`
+	FeatureList         []string        `json:"featureList,omitempty"`
+	URL                 string          `json:"url,omitempty"`
+	Brand               *schemaThing    `json:"brand,omitempty"`
+	Author              *schemaThing    `json:"author,omitempty"`
+	Provider            *schemaThing    `json:"provider,omitempty"`
+	Audience            *schemaAudience `json:"audience,omitempty"`
+	EncodingFormat      []string        `json:"encodingFormat,omitempty"`
+	DateModified        string          `json:"dateModified,omitempty"`
+	Offers              *schemaOffer    `json:"offers,omitempty"`
+	types               []string
+}
+
+// schemaInput is what a document is built from.
+type schemaInput struct {
+	Kind         string // a key of schemaKinds
+	SKU          string
+	Name         string
+	Description  string
+	Category     string
+	Author       string
+	Formats      []string
+	URL          string
+	Price        *float64 // no offer when nil
+	Currency     string
+	Availability string
+	OfferURL     string
+	UpdatedAt    time.Time
+}
+
+// schemaCurrencies maps catalog currencies to the codes put in offers.
+var schemaCurrencies = map[string]string{"STARS": "XTR"}
+
+var schemaAvailability = map[string]string{
+	"available": "https://schema.org/InStock",
+	"sold_out":  "https://schema.org/SoldOut",
+	"archived":  "https://schema.org/Discontinued",
+}
+
+func (d *schemaDoc) is(t string) bool {
+	return oneOf(t, d.types)
+}
+
+// buildSchema lays out the structural markup for in.
+func buildSchema(in schemaInput) *schemaDoc {
+	types := schemaKinds[in.Kind]
+	if types == nil {
+		types = schemaKinds["product"]
+	}
+	d := &schemaDoc{Context: "https://schema.org", Type: types[0], types: types,
+		ID: in.URL, URL: in.URL, SKU: in.SKU, Name: in.Name, Description: in.Description}
+	if len(types) > 1 {
+		d.Type = types
+	}
+	creativeWork := d.is("3DModel") || d.is("SoftwareApplication")
+	if d.is("Service") {
+		d.SKU = ""
+	}
+
+	switch {
+	case d.is("SoftwareApplication"):
+		d.ApplicationCategory = in.Category
+	case d.is("Service"):
+		d.ServiceType = in.Category
+	default:
+		d.Category = in.Category
+	}
+	if in.Author != "" {
+		if d.is("Service") {
+			d.Provider = &schemaThing{Type: "Organization", Name: in.Author}
+		} else {
+			d.Brand = &schemaThing{Type: "Brand", Name: in.Author}
+		}
+		if creativeWork {
+			d.Author = &schemaThing{Type: "Person", Name: in.Author}
+		}
+	}
+	if d.is("3DModel") {
+		d.EncodingFormat = in.Formats
+	}
+	if creativeWork && !in.UpdatedAt.IsZero() {
+		d.DateModified = in.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	if in.Price != nil {
+		d.Offers = &schemaOffer{
+			Type:          "Offer",
+			Price:         strconv.FormatFloat(*in.Price, 'f', -1, 64),
+			PriceCurrency: in.Currency,
+			Availability:  in.Availability,
+			URL:           in.OfferURL,
+			Seller:        schemaThing{Type: "Organization", Name: marketplaceName},
+		}
+		if code, ok := schemaCurrencies[in.Currency]; ok {
+			d.Offers.PriceCurrency = code
+		}
+	}
+	return d
+}
+
+// assetSchemaInput describes a catalog asset; base is the public URL of
+// the API.
+func assetSchemaInput(base string, a store.Asset) schemaInput {
+	in := schemaInput{
+		Kind:         schemaType(a.Category),
+		SKU:          a.ID,
+		Name:         a.Name,
+		Description:  a.Description,
+		Category:     a.Category,
+		Author:       a.Author,
+		URL:          base + "/api/b2a/assets/" + url.PathEscape(a.ID),
+		Price:        &a.Price,
+		Currency:     a.Currency,
+		Availability: schemaAvailability[a.Status],
+		UpdatedAt:    a.UpdatedAt,
+	}
+	for _, f := range strings.Split(a.Format, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			in.Formats = append(in.Formats, f)
+		}
+	}
+	if a.Currency == "TON" && a.Status == "available" {
+		in.OfferURL = base + "/api/ton/orders"
+	}
+	return in
+}
+
+// schemaEnrichment is the descriptive text the LLM may add.
+type schemaEnrichment struct {
+	Description string   `json:"description" jsonschema:"required"`
+	Summary     string   `json:"summary"`
+	Keywords    []string `json:"keywords"`
+	Audience    string   `json:"audience"`
+	Features    []string `json:"features"`
+}
+
+func cleanText(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > max {
+		s = string(r[:max])
+	}
+	return s
+}
+
+func cleanList(list []string, maxItems, maxLen int) []string {
+	var out []string
+	for _, s := range list {
+		if s = cleanText(s, maxLen); s != "" && !oneOf(s, out) && len(out) < maxItems {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// enrich copies the descriptive fields into properties the document's types
+// have, trimmed to sane lengths.
+func (d *schemaDoc) enrich(e schemaEnrichment) {
+	if s := cleanText(e.Description, 5000); s != "" {
+		d.Description = s
+	}
+	d.DisambiguatingDesc = cleanText(e.Summary, 300)
+	if !d.is("Service") {
+		d.Keywords = cleanList(e.Keywords, 12, 60)
+	}
+	if s := cleanText(e.Audience, 100); s != "" {
+		d.Audience = &schemaAudience{Type: "Audience", AudienceType: s}
+	}
+	if d.is("SoftwareApplication") {
+		d.FeatureList = cleanList(e.Features, 20, 200)
+	}
+}
+
+func allowedProperties(types []string) []string {
+	allowed := append([]string(nil), schemaProperties["Thing"]...)
+	for _, t := range types {
+		allowed = append(allowed, schemaProperties[t]...)
+	}
+	return allowed
+}
+
+// validateJSONLD checks a decoded document: the context, known types, only
+// properties those types have, a name and a well-formed offer. It returns
+// the problems found.
+func validateJSONLD(doc map[string]interface{}) []string {
+	var problems []string
+	if doc["@context"] != "https://schema.org" {
+		problems = append(problems, `@context must be "https://schema.org"`)
+	}
+	var types []string
+	switch t := doc["@type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			s, _ := v.(string)
+			types = append(types, s)
+		}
+	}
+	if len(types) == 0 {
+		problems = append(problems, "@type is required")
+	}
+	for _, t := range types {
+		if _, ok := schemaProperties[t]; !ok || t == "Thing" || t == "Offer" {
+			problems = append(problems, fmt.Sprintf("unsupported @type %q", t))
+		}
+	}
+	allowed := allowedProperties(types)
+	for k := range doc {
+		if !oneOf(k, allowed) {
+			problems = append(problems, fmt.Sprintf("%s is not a property of %s", k, strings.Join(types, "/")))
+		}
+	}
+	if name, _ := doc["name"].(string); strings.TrimSpace(name) == "" {
+		problems = append(problems, "name is required")
+	}
+	for _, k := range []string{"brand", "author", "provider", "audience"} {
+		if v, ok := doc[k]; ok {
+			if obj, _ := v.(map[string]interface{}); obj == nil || obj["@type"] == nil {
+				problems = append(problems, k+" must be a typed object")
+			}
+		}
+	}
+	if v, ok := doc["offers"]; ok {
+		offer, _ := v.(map[string]interface{})
+		if offer == nil || offer["@type"] != "Offer" {
+			return append(problems, "offers must be an Offer")
+		}
+		for k := range offer {
+			if !oneOf(k, schemaProperties["Offer"]) {
+				problems = append(problems, fmt.Sprintf("offers.%s is not a property of Offer", k))
+			}
+		}
+		price, _ := offer["price"].(string)
+		if n, err := strconv.ParseFloat(price, 64); err != nil || n < 0 {
+			problems = append(problems, "offers.price must be a non-negative number")
+		}
+		if c, _ := offer["priceCurrency"].(string); c == "" {
+			problems = append(problems, "offers.priceCurrency is required")
+		}
+		if a, ok := offer["availability"].(string); ok && !strings.HasPrefix(a, "https://schema.org/") {
+			problems = append(problems, "offers.availability must be a schema.org ItemAvailability")
+		}
+	}
+	return problems
+}
+
+// render encodes and validates the document. The JSON is HTML-escaped, so
+// it is also safe inside a <script> tag.
+func (d *schemaDoc) render() ([]byte, map[string]interface{}, error) {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return nil, nil, err
+	}
+	if problems := validateJSONLD(obj); len(problems) > 0 {
+		return nil, nil, fmt.Errorf("invalid JSON-LD: %s", strings.Join(problems, "; "))
+	}
+	return body, obj, nil
+}
+
+// enrichSchema asks the LLM for the descriptive fields of d.
+func enrichSchema(ctx context.Context, d *schemaDoc, in schemaInput) error {
+	schema := schemaFor(reflect.TypeOf(schemaEnrichment{}))
+	schemaJSON, _ := json.Marshal(schema)
+	p, err := renderPrompt(ctx, "b2a.schema", gin.H{
+		"Schema": string(schemaJSON), "Name": in.Name, "Type": in.Kind, "Category": in.Category, "Description": in.Description,
+	})
+	if err != nil {
+		return err
+	}
+	var e schemaEnrichment
+	if _, err := generateJSON(ctx, p.Request(), schema, &e, 1); err != nil {
+		return err
+	}
+	d.enrich(e)
+	return nil
+}
+
+// handleB2ASchema builds JSON-LD for a catalog asset (asset_id) or for the
+// fields in the body, lets the LLM enrich the text unless "enrich" is false,
+// and returns the validated object and its <script> tag ("response"). If the
+// enrichment fails or breaks validation the structural markup is served.
+func handleB2ASchema(c *gin.Context) {
+	var req struct {
+		AssetID     string `json:"asset_id"` // fills the rest from the catalog
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Price       string `json:"price"`
+		Currency    string `json:"currency"`
+		Type        string `json:"type"` // "3dmodel", "software", "service", "product"
+		Category    string `json:"category"`
+		Author      string `json:"author"`
+		Enrich      *bool  `json:"enrich"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var in schemaInput
+	if req.AssetID != "" {
+		a, ok := findAsset(c, req.AssetID)
+		if !ok {
+			return
+		}
+		in = assetSchemaInput(baseURL(c), a)
+	} else {
+		in = schemaInput{Kind: strings.ToLower(req.Type), Name: strings.TrimSpace(req.Name),
+			Description: strings.TrimSpace(req.Description), Category: req.Category, Author: req.Author,
+			Currency: strings.ToUpper(strings.TrimSpace(req.Currency))}
+		if in.Kind == "" {
+			in.Kind = "product"
+		}
+		if _, ok := schemaKinds[in.Kind]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be 3dmodel, software, service or product"})
+			return
+		}
+		if in.Name == "" || in.Description == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name and Description are required"})
+			return
+		}
+		if price := strings.TrimSpace(req.Price); price != "" {
+			n, err := strconv.ParseFloat(price, 64)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "price must be a non-negative number"})
+				return
+			}
+			if in.Currency == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "currency is required with a price"})
+				return
+			}
+			in.Price = &n
+		}
+	}
+
+	doc := buildSchema(in)
+	enriched := false
+	if req.Enrich == nil || *req.Enrich {
+		if err := enrichSchema(c.Request.Context(), doc, in); err != nil {
+			log.Printf("B2A schema enrichment: %v", err)
+		} else {
+			enriched = true
+		}
+	}
+	body, obj, err := doc.render()
+	if err != nil && enriched {
+		log.Printf("B2A schema: enriched markup rejected: %v", err)
+		enriched = false
+		body, obj, err = buildSchema(in).render()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"response": scriptTag(body), "jsonld": obj, "enriched": enriched})
+}
+
+func scriptTag(body []byte) string {
+	return `<script type="application/ld+json">` + string(body) + `</script>`
+}
+
+// etagMatches reports whether an If-None-Match header names etag.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAssetSchema serves the JSON-LD of ?id= with an ETag, answering 304
+// when the client already has it.
+func handleAssetSchema(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+		return
+	}
+	a, ok := findAsset(c, id)
+	if !ok {
+		return
+	}
+	body, _, err := buildSchema(assetSchemaInput(baseURL(c), a)).render()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=300")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/ld+json; charset=utf-8", body)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// chatHistoryTokens is the budget for summary plus history sent with
+	// each chat turn (CHAT_HISTORY_TOKENS).
+	chatHistoryTokens = 3000
+	// chatSummarize folds trimmed messages into a running summary instead
+	// of dropping them (CHAT_SUMMARIZE=0 disables it).
+	chatSummarize = true
+)
+
+func setupSessions() {
+	if n, err := strconv.Atoi(os.Getenv("CHAT_HISTORY_TOKENS")); err == nil && n > 0 {
+		chatHistoryTokens = n
+	}
+	chatSummarize = os.Getenv("CHAT_SUMMARIZE") != "0"
+}
+
+// getSession loads the session if it exists and belongs to userID. Callers
+// without a user have no sessions.
+func getSession(id, userID string) (store.ChatSession, bool) {
+	if userID == "" {
+		return store.ChatSession{}, false
+	}
+	s, err := db.GetSession(id)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Session load error: %v", err)
+		}
+		return s, false
+	}
+	return s, s.UserID == userID
+}
+
+// estimateTokens is a rough count good enough for budgeting: about three
+// characters per token for mixed Russian and English text.
+func estimateTokens(s string) int {
+	return utf8.RuneCountInString(s)/3 + 1
+}
+
+// buildChatRequest turns the session history plus the new prompt into a
+// messages array that fits chatHistoryTokens. Messages that no longer fit
+// are summarized (or dropped if summarizing fails); the returned count is
+// the new SummarizedCount. It fails only when the system prompt does not
+// render.
+func buildChatRequest(ctx context.Context, s store.ChatSession, prompt string) (ChatRequest, string, int, error) {
+	system, promptID := s.SystemPrompt, ""
+	if system == "" {
+		p, err := renderPrompt(ctx, "chat.default", gin.H{"Input": prompt})
+		if err != nil {
+			return ChatRequest{}, "", 0, err
+		}
+		system, promptID = p.System, p.ID()
+	}
+
+	summary, summarized := s.Summary, s.SummarizedCount
+	history := s.Messages[summarized:]
+
+	budget := chatHistoryTokens - estimateTokens(prompt) - estimateTokens(summary)
+	keep := len(history)
+	for keep > 0 && budget-estimateTokens(history[keep-1].Content) >= 0 {
+		budget -= estimateTokens(history[keep-1].Content)
+		keep--
+	}
+	// keep is now the index of the oldest message that still fits; never
+	// start the kept history with an assistant turn
+	cut := keep
+	if cut < len(history) && history[cut].Role == "assistant" {
+		cut++
+	}
+
+	if cut > 0 {
+		if chatSummarize {
+			if updated, err := summarizeHistory(ctx, summary, history[:cut]); err == nil {
+				summary = updated
+				summarized += cut
+			} else {
+				log.Printf("Chat summary error: %v", err)
+			}
+		}
+	}
+
+	if summary != "" {
+		system += "\n\nКраткое содержание предыдущей беседы:\n" + summary
+	}
+
+	messages := make([]ChatMessage, 0, len(history)-cut+1)
+	for _, m := range history[cut:] {
+		messages = append(messages, ChatMessage{Role: m.Role, Content: m.Content})
+	}
+	messages = append(messages, ChatMessage{Role: "user", Content: prompt})
+	return ChatRequest{System: system, Messages: messages, PromptID: promptID}, summary, summarized, nil
+}
+
+func summarizeHistory(ctx context.Context, summary string, messages []store.ChatMessage) (string, error) {
+	var b strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&b, "%s: %s\n", m.Role, m.Content)
+	}
+	return callPrompt(ctx, "chat.summary", gin.H{"Summary": summary, "Messages": b.String()})
+}
+
+// appendTurn records a completed user/assistant exchange.
+func appendTurn(id, prompt, answer, summary string, summarized int) {
+	now := time.Now()
+	err := db.AppendToSession(id, store.SessionUpdate{
+		Messages: []store.ChatMessage{
+			{Role: "user", Content: prompt, CreatedAt: now},
+			{Role: "assistant", Content: answer, CreatedAt: now},
+		},
+		Summary:         summary,
+		SummarizedCount: summarized,
+		Title:           truncateRunes(prompt, 60),
+	})
+	if err != nil {
+		log.Printf("Session save error: %v", err)
+	}
+}
+
+// chatInSession answers prompt within a session, as JSON or SSE.
+func chatInSession(c *gin.Context, s store.ChatSession, prompt string) {
+	setCallUser(c, s.UserID)
+	req, summary, summarized, err := buildChatRequest(c.Request.Context(), s, prompt)
+	if err != nil {
+		respondLLMError(c, err)
+		return
+	}
+
+	if wantsStream(c) {
+		streamChatCompletion(c, req, func(comp Completion) gin.H {
+			appendTurn(s.ID, prompt, comp.Text, summary, summarized)
+			return gin.H{"session_id": s.ID}
+		})
+		return
+	}
+
+	comp, err := completeLLM(c.Request.Context(), req)
+	if err != nil {
+		respondLLMError(c, err)
+		return
+	}
+	appendTurn(s.ID, prompt, comp.Text, summary, summarized)
+	c.JSON(http.StatusOK, gin.H{"response": comp.Text, "session_id": s.ID, "meta": comp})
+}
+
+func handleCreateSession(c *gin.Context) {
+	userID := callerID(c)
+	if userID == "" {
+		abortNoCaller(c)
+		return
+	}
+	var req struct {
+		Title        string `json:"title"`
+		SystemPrompt string `json:"systemPrompt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	id, err := randomToken("chat_", 12)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	s := &store.ChatSession{
+		ID:           id,
+		UserID:       userID,
+		Title:        req.Title,
+		SystemPrompt: req.SystemPrompt,
+		Messages:     []store.ChatMessage{},
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := db.CreateSession(s); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+func handleListSessions(c *gin.Context) {
+	userID := callerID(c)
+	if userID == "" {
+		abortNoCaller(c)
+		return
+	}
+	list, err := db.ListSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": list})
+}
+
+func handleGetSession(c *gin.Context) {
+	s, ok := getSession(c.Param("id"), callerID(c))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+func handleSessionMessage(c *gin.Context) {
+	userID := callerID(c)
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content is required"})
+		return
+	}
+	s, ok := getSession(c.Param("id"), userID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	chatInSession(c, s, req.Content)
+}
+
+func handleDeleteSession(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := getSession(id, callerID(c)); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err := db.DeleteSession(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"ezhik-ideas/store"
+)
+
+func TestSessionOwnership(t *testing.T) {
+	r, _ := newTestServer(t)
+
+	var a, b store.ChatSession
+	do(t, r, "POST", "/api/chat/sessions", map[string]string{"user_id": "1", "title": "mine"}, &a)
+	do(t, r, "POST", "/api/chat/sessions", map[string]string{"user_id": "1"}, &b)
+	if !strings.HasPrefix(a.ID, "chat_") || a.ID == b.ID || a.UserID != "1" {
+		t.Fatalf("sessions %q and %q for %q", a.ID, b.ID, a.UserID)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+		want         int
+	}{
+		{"GET", "/api/chat/sessions/" + a.ID + "?user_id=2", nil, http.StatusNotFound},
+		{"GET", "/api/chat/sessions/" + a.ID, nil, http.StatusNotFound},
+		{"POST", "/api/chat/sessions/" + a.ID + "/messages", map[string]string{"user_id": "2", "content": "hi"}, http.StatusNotFound},
+		{"POST", "/api/ai", map[string]string{"user_id": "2", "session_id": a.ID, "prompt": "hi"}, http.StatusNotFound},
+		{"DELETE", "/api/chat/sessions/" + a.ID + "?user_id=2", nil, http.StatusNotFound},
+		{"GET", "/api/chat/sessions/" + a.ID + "?user_id=1", nil, http.StatusOK},
+		{"POST", "/api/chat/sessions/" + a.ID + "/messages", map[string]string{"user_id": "1", "content": "hi"}, http.StatusOK},
+		{"POST", "/api/ai", map[string]string{"user_id": "1", "session_id": a.ID, "prompt": "hi"}, http.StatusOK},
+	} {
+		if w := do(t, r, tc.method, tc.path, tc.body, nil); w.Code != tc.want {
+			t.Errorf("%s %s: %d %s, want %d", tc.method, tc.path, w.Code, w.Body, tc.want)
+		}
+	}
+
+	if w := do(t, r, "POST", "/api/chat/sessions", map[string]string{"title": "anonymous"}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("anonymous session: %d, want 400", w.Code)
+	}
+	if w := do(t, r, "GET", "/api/chat/sessions", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("anonymous list: %d, want 400", w.Code)
+	}
+
+	var list struct {
+		Sessions []store.ChatSession `json:"sessions"`
+	}
+	do(t, r, "GET", "/api/chat/sessions?user_id=2", nil, &list)
+	if len(list.Sessions) != 0 {
+		t.Errorf("user 2 lists %d sessions", len(list.Sessions))
+	}
+	do(t, r, "GET", "/api/chat/sessions?user_id=1", nil, &list)
+	if len(list.Sessions) != 2 {
+		t.Errorf("user 1 lists %d sessions, want 2", len(list.Sessions))
+	}
+}
+
+func TestSessionPromptError(t *testing.T) {
+	r, _ := newTestServer(t)
+	var s store.ChatSession
+	do(t, r, "POST", "/api/chat/sessions", map[string]string{"user_id": "1"}, &s)
+
+	prompts.mu.Lock()
+	set := prompts.sets["chat.default"]
+	delete(prompts.sets, "chat.default")
+	prompts.mu.Unlock()
+	t.Cleanup(func() {
+		prompts.mu.Lock()
+		prompts.sets["chat.default"] = set
+		prompts.mu.Unlock()
+	})
+
+	var got struct {
+		Error string `json:"error"`
+	}
+	w := do(t, r, "POST", "/api/chat/sessions/"+s.ID+"/messages", map[string]string{"user_id": "1", "content": "hi"}, &got)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(got.Error, "chat.default") {
+		t.Fatalf("%d %s, want 500 naming the template", w.Code, w.Body)
+	}
+	if s, _ = db.GetSession(s.ID); len(s.Messages) != 0 {
+		t.Errorf("%d messages saved after a failed turn", len(s.Messages))
+	}
+}
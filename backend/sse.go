@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wantsStream reports whether the client asked for Server-Sent Events,
+// either with ?stream=1 or an Accept: text/event-stream header.
+func wantsStream(c *gin.Context) bool {
+	switch c.Query("stream") {
+	case "1", "true":
+		return true
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+func startSSE(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+}
+
+func sendSSE(c *gin.Context, event string, data interface{}) {
+	c.SSEvent(event, data)
+	c.Writer.Flush()
+}
+
+// streamFailedKey marks a stream that ended in an "error" event. The 200
+// has gone out by then, so middleware checks this instead of the status.
+const streamFailedKey = "stream_failed"
+
+// sendSSEError sends the error envelope as an "error" event and marks the
+// request as failed.
+func sendSSEError(c *gin.Context, err error) {
+	c.Set(streamFailedKey, true)
+	_, body := llmErrorEnvelope(err)
+	sendSSE(c, "error", body)
+}
+
+// requestFailed reports whether the handler failed, either with an error
+// status or with an "error" event after the stream started.
+func requestFailed(c *gin.Context) bool {
+	return c.Writer.Status() >= http.StatusBadRequest || c.GetBool(streamFailedKey)
+}
+
+// streamChatCompletion relays a completion as "token" events followed by a
+// final "done" event carrying the full response. onDone, if set, runs before
+// the "done" event and may add fields to it.
+func streamChatCompletion(c *gin.Context, req ChatRequest, onDone func(Completion) gin.H) {
+	startSSE(c)
+	comp, err := streamChat(c.Request.Context(), req, func(delta string) {
+		sendSSE(c, "token", gin.H{"text": delta})
+	})
+	if err != nil {
+		sendSSEError(c, err)
+		return
+	}
+	done := gin.H{"response": comp.Text, "meta": comp}
+	if onDone != nil {
+		for k, v := range onDone(comp) {
+			done[k] = v
+		}
+	}
+	sendSSE(c, "done", done)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Telegram Stars payments. /api/stars/pay creates an XTR invoice link the
+// Mini App opens with Telegram.WebApp.openInvoice; Telegram then sends
+// pre_checkout_query and successful_payment updates to /api/telegram/webhook
+// and the tier or credits are granted only after the successful payment
+// arrives.
+
+const starsCurrency = "XTR"
+
+// starsProduct is something sold for Stars: a subscription tier or one
+// credit of a feature.
+type starsProduct struct {
+	Title       string
+	Description string
+	Price       int64 // in Stars
+	Tier        string
+	Days        int // tier duration, 0 never expires
+	Feature     string
+	Credits     int64
+}
+
+var (
+	starsProducts = map[string]starsProduct{}
+	// starsWebhookSecret must match X-Telegram-Bot-Api-Secret-Token, the
+	// secret_token given to setWebhook.
+	starsWebhookSecret string
+)
+
+// setupStars runs after setupEntitlements; products come from the tiers and
+// the features sold per call.
+func setupStars() {
+	if telegramBotToken != "" {
+		bot = newBotAPI(envOr("TELEGRAM_API_URL", "https://api.telegram.org"), telegramBotToken)
+	}
+	starsWebhookSecret = os.Getenv("TELEGRAM_WEBHOOK_SECRET")
+	if bot != nil && starsWebhookSecret == "" {
+		log.Printf("TELEGRAM_WEBHOOK_SECRET is not set: the bot webhook and Stars payments are disabled")
+	}
+
+	for _, t := range subscriptionTiers {
+		desc := "Every feature of the tier, forever."
+		if t.Days > 0 {
+			desc = fmt.Sprintf("Every feature of the tier for %d days.", t.Days)
+		}
+		starsProducts[t.ID] = starsProduct{Title: t.Name, Description: desc, Price: t.Price, Tier: t.ID, Days: t.Days}
+	}
+	for _, f := range features {
+		if f.Price > 0 {
+			starsProducts[f.ID] = starsProduct{Title: f.Name, Description: "One " + f.Name + " call.",
+				Price: f.Price, Feature: f.ID, Credits: 1}
+		}
+	}
+}
+
+func handleStarsPay(c *gin.Context) {
+	var req struct {
+		UserID  string `json:"user_id"`
+		Product string `json:"product"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	userID, ok := requestUser(c, req.UserID)
+	if !ok {
+		return
+	}
+	if req.Product == "" {
+		req.Product = "premium"
+	}
+	product, ok := starsProducts[req.Product]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown product"})
+		return
+	}
+	// Without the secret anyone could post the successful_payment for the
+	// payload returned below
+	if bot == nil || starsWebhookSecret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Telegram payments are not configured"})
+		return
+	}
+	if product.Tier != "" {
+		cur, err := activeTier(db, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if reason := tierConflict(cur, product.Tier, product.Days); reason != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": reason})
+			return
+		}
+	}
+
+	setCallUser(c, userID)
+
+	payload, err := randomToken("stars_", 12)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	payment := store.Payment{Payload: payload, UserID: userID, Product: req.Product, Currency: starsCurrency, Amount: product.Price}
+	if err := db.CreatePayment(&payment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	link, err := bot.CreateInvoiceLink(c.Request.Context(), invoiceParams{
+		Title:       product.Title,
+		Description: product.Description,
+		Payload:     payload,
+		Currency:    starsCurrency,
+		Prices:      []labeledPrice{{Label: product.Title, Amount: product.Price}},
+	})
+	if err != nil {
+		log.Printf("Invoice error: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Could not create the invoice"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoice_link": link,
+		"payload":      payload,
+		"product":      req.Product,
+		"amount":       product.Price,
+		"currency":     starsCurrency,
+	})
+}
+
+func checkStars(c *gin.Context) {
+	var req struct {
+		UserID string `json:"user_id"`
+		// Payload optionally asks for the status of an invoice.
+		Payload string `json:"payload"`
+	}
+	// The body is optional once the caller is authenticated by initData
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	userID, ok := requestUser(c, req.UserID)
+	if !ok {
+		return
+	}
+
+	setCallUser(c, userID)
+
+	resp := gin.H{
+		"is_premium": isPremium(userID),
+		"user_id":    userID,
+	}
+	if p, err := db.GetPremium(userID); err == nil && p.Active(time.Now()) {
+		resp["tier"] = p.Tier
+		resp["expires_at"] = p.ExpiresAt
+	}
+	if req.Payload != "" {
+		p, err := db.GetPayment(req.Payload)
+		if err == nil && p.UserID == userID {
+			resp["payment_status"] = p.Status
+		} else {
+			resp["payment_status"] = "unknown"
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleTelegramWebhook receives bot updates. Failures that a redelivery
+// could fix answer 500 so Telegram retries; everything else answers 200.
+func handleTelegramWebhook(c *gin.Context) {
+	if bot == nil || starsWebhookSecret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Telegram bot is not configured"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Telegram-Bot-Api-Secret-Token")), []byte(starsWebhookSecret)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook secret"})
+		return
+	}
+	var update botUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update"})
+		return
+	}
+
+	switch {
+	case update.PreCheckoutQuery != nil:
+		q := update.PreCheckoutQuery
+		reason := checkPreCheckout(q)
+		if reason != "" {
+			log.Printf("Pre-checkout %s declined: %s", q.InvoicePayload, reason)
+		}
+		if err := bot.AnswerPreCheckoutQuery(c.Request.Context(), q.ID, reason == "", reason); err != nil {
+			log.Printf("Pre-checkout answer error: %v", err)
+		}
+	case update.Message != nil && update.Message.SuccessfulPayment != nil:
+		if err := confirmStarsPayment(update.Message.SuccessfulPayment); err != nil {
+			log.Printf("Payment error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// checkPreCheckout returns why a checkout must be declined, "" to accept it.
+func checkPreCheckout(q *preCheckoutQuery) string {
+	p, err := db.GetPayment(q.InvoicePayload)
+	if errors.Is(err, store.ErrNotFound) {
+		return "Invoice not found"
+	}
+	if err != nil {
+		log.Printf("Payment lookup error: %v", err)
+		return "Payments are temporarily unavailable, please try again"
+	}
+	switch {
+	case p.Status != store.PaymentPending:
+		return "This invoice is already paid"
+	case p.UserID != strconv.FormatInt(q.From.ID, 10):
+		return "This invoice belongs to another user"
+	case p.Currency != q.Currency || p.Amount != q.TotalAmount:
+		return "Invoice amount mismatch"
+	}
+	if prod := starsProducts[p.Product]; prod.Tier != "" {
+		cur, err := activeTier(db, p.UserID)
+		if err != nil {
+			log.Printf("Tier lookup error: %v", err)
+			return "Payments are temporarily unavailable, please try again"
+		}
+		return tierConflict(cur, prod.Tier, prod.Days)
+	}
+	return ""
+}
+
+// confirmStarsPayment records the charge and grants what was paid for in
+// one transaction. The payment is claimed first, so a redelivered or
+// concurrent update grants nothing, and a failed grant leaves it pending
+// for Telegram to redeliver.
+func confirmStarsPayment(sp *successfulPayment) error {
+	p, err := db.GetPayment(sp.InvoicePayload)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Printf("Payment for unknown invoice %s, charge %s", sp.InvoicePayload, sp.TelegramPaymentChargeID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p.Status == store.PaymentPaid {
+		return nil
+	}
+	if p.Currency != sp.Currency || p.Amount != sp.TotalAmount {
+		log.Printf("Payment %s mismatch: got %d %s, want %d %s (charge %s)",
+			p.Payload, sp.TotalAmount, sp.Currency, p.Amount, p.Currency, sp.TelegramPaymentChargeID)
+		return nil
+	}
+
+	var first bool
+	claimed, err := db.ClaimPayment(p.Payload, sp.TelegramPaymentChargeID, sp.ProviderPaymentChargeID, func(tx *store.Tx) error {
+		var err error
+		first, err = fulfill(tx, p)
+		return err
+	})
+	if err != nil || !claimed {
+		return err
+	}
+	if err := db.AddStat("stars", "", p.Product, p.Amount); err != nil {
+		log.Printf("Stats error: %v", err)
+	}
+	if first {
+		recordStat("premium", "", "stars")
+	}
+	return nil
+}
+
+// fulfill grants what a paid invoice bought inside the transaction that
+// claims the payment. It reports whether a tier went to a user who had none.
+func fulfill(tx *store.Tx, p store.Payment) (bool, error) {
+	prod, ok := starsProducts[p.Product]
+	switch {
+	case !ok:
+		return false, fmt.Errorf("payment %s: unknown product %q", p.Payload, p.Product)
+	case prod.Tier != "":
+		first, err := applyTier(tx, p.UserID, prod.Tier, "stars", prod.Days)
+		if errors.Is(err, errTierConflict) {
+			// The tier changed between pre-checkout and payment; retrying
+			// will not help, the charge is kept for a manual refund
+			log.Printf("Payment %s (charge for %s) not granted: %v", p.Payload, prod.Tier, err)
+			return false, nil
+		}
+		return first, err
+	default:
+		_, err := tx.AddCredits(p.UserID, prod.Feature, prod.Credits, "stars")
+		return false, err
+	}
+}
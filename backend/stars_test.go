@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeBotAPI records what the server asks of Telegram, like cmd/fakebotapi.
+type fakeBotAPI struct {
+	mu       sync.Mutex
+	invoices []invoiceParams
+	answers  map[string]bool // pre_checkout_query id -> ok
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result interface{} = true
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/createInvoiceLink"):
+		var p invoiceParams
+		json.NewDecoder(r.Body).Decode(&p)
+		f.invoices = append(f.invoices, p)
+		result = "https://t.me/$" + p.Payload
+	case strings.HasSuffix(r.URL.Path, "/answerPreCheckoutQuery"):
+		var a struct {
+			ID string `json:"pre_checkout_query_id"`
+			OK bool   `json:"ok"`
+		}
+		json.NewDecoder(r.Body).Decode(&a)
+		f.answers[a.ID] = a.OK
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(gin.H{"ok": true, "result": result})
+}
+
+// starsServer is the test server wired to a fake Bot API with the webhook
+// secret "s3cret".
+func starsServer(t *testing.T) (*gin.Engine, *fakeBotAPI) {
+	t.Helper()
+	r, _ := newTestServer(t)
+	fake := &fakeBotAPI{answers: map[string]bool{}}
+	api := httptest.NewServer(fake)
+	prevBot, prevSecret := bot, starsWebhookSecret
+	setupStars()
+	bot, starsWebhookSecret = newBotAPI(api.URL, "TEST"), "s3cret"
+	t.Cleanup(func() {
+		bot, starsWebhookSecret = prevBot, prevSecret
+		api.Close()
+	})
+	return r, fake
+}
+
+func webhook(r http.Handler, secret string, update botUpdate) int {
+	data, _ := json.Marshal(update)
+	req := httptest.NewRequest(http.MethodPost, "/api/telegram/webhook", strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestStarsPaymentFlow(t *testing.T) {
+	r, fake := starsServer(t)
+
+	var invoice struct {
+		Payload string `json:"payload"`
+		Amount  int64  `json:"amount"`
+	}
+	w := do(t, r, http.MethodPost, "/api/stars/pay", gin.H{"user_id": "42", "product": "pro"}, &invoice)
+	if w.Code != http.StatusOK || len(fake.invoices) != 1 || fake.invoices[0].Payload != invoice.Payload {
+		t.Fatalf("POST /api/stars/pay: %d %s, invoices %+v", w.Code, w.Body, fake.invoices)
+	}
+
+	checkout := botUpdate{UpdateID: 1, PreCheckoutQuery: &preCheckoutQuery{ID: "q1", From: botUser{ID: 42},
+		Currency: starsCurrency, TotalAmount: invoice.Amount, InvoicePayload: invoice.Payload}}
+	paid := botUpdate{UpdateID: 2, Message: &botMessage{MessageID: 1, From: botUser{ID: 42},
+		SuccessfulPayment: &successfulPayment{Currency: starsCurrency, TotalAmount: invoice.Amount,
+			InvoicePayload: invoice.Payload, TelegramPaymentChargeID: "charge_1"}}}
+
+	if code := webhook(r, "wrong", checkout); code != http.StatusUnauthorized {
+		t.Errorf("wrong webhook secret: %d, want 401", code)
+	}
+	if code := webhook(r, "wrong", paid); code != http.StatusUnauthorized {
+		t.Errorf("wrong webhook secret: %d, want 401", code)
+	}
+	if p, _ := db.GetPremium("42"); p.Tier != "" {
+		t.Fatalf("unauthenticated update granted %s", p.Tier)
+	}
+
+	if code := webhook(r, "s3cret", checkout); code != http.StatusOK || !fake.answers["q1"] {
+		t.Fatalf("pre_checkout_query: %d, answers %v", code, fake.answers)
+	}
+	if code := webhook(r, "s3cret", paid); code != http.StatusOK {
+		t.Fatalf("successful_payment: %d", code)
+	}
+	p, err := db.GetPremium("42")
+	if err != nil || p.Tier != "pro" || p.ExpiresAt == nil {
+		t.Fatalf("tier after payment = %+v, %v", p, err)
+	}
+	expires := *p.ExpiresAt
+
+	// Telegram redelivers the update: nothing is granted twice
+	if code := webhook(r, "s3cret", paid); code != http.StatusOK {
+		t.Fatalf("redelivered successful_payment: %d", code)
+	}
+	if p, _ := db.GetPremium("42"); !p.ExpiresAt.Equal(expires) {
+		t.Errorf("redelivery moved expiry from %v to %v", expires, p.ExpiresAt)
+	}
+	if got, _ := db.GetPayment(invoice.Payload); got.Status != store.PaymentPaid || got.ChargeID != "charge_1" {
+		t.Errorf("payment = %+v", got)
+	}
+	stats, _ := db.ListStats(store.StatsFilter{Metric: "stars"})
+	if len(stats) != 1 || stats[0].Count != invoice.Amount {
+		t.Errorf("stars stats = %+v, want one row of %d", stats, invoice.Amount)
+	}
+
+	// A paid invoice cannot be checked out again
+	checkout.PreCheckoutQuery.ID = "q2"
+	if code := webhook(r, "s3cret", checkout); code != http.StatusOK || fake.answers["q2"] {
+		t.Errorf("checkout of a paid invoice: %d, accepted %v", code, fake.answers["q2"])
+	}
+}
+
+func TestStarsPayRefusesDowngrade(t *testing.T) {
+	r, fake := starsServer(t)
+	if err := grantTier("42", "premium", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	w := do(t, r, http.MethodPost, "/api/stars/pay", gin.H{"user_id": "42", "product": "pro"}, nil)
+	if w.Code != http.StatusConflict || len(fake.invoices) != 0 {
+		t.Errorf("pro invoice over lifetime premium: %d, invoices %d", w.Code, len(fake.invoices))
+	}
+}
+
+func TestStarsNeedWebhookSecret(t *testing.T) {
+	r, fake := starsServer(t)
+	starsWebhookSecret = ""
+
+	update := botUpdate{Message: &botMessage{SuccessfulPayment: &successfulPayment{
+		Currency: starsCurrency, TotalAmount: 1, InvoicePayload: "stars_forged"}}}
+	if code := webhook(r, "", update); code != http.StatusServiceUnavailable {
+		t.Errorf("webhook without a configured secret: %d, want 503", code)
+	}
+	if w := do(t, r, http.MethodPost, "/api/stars/pay", gin.H{"user_id": "42", "product": "pro"}, nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("pay without a configured secret: %d %s, want 503", w.Code, w.Body)
+	}
+	if len(fake.invoices) != 0 {
+		t.Errorf("%d invoices created", len(fake.invoices))
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Persistent daily statistics. Every /api request is counted per endpoint,
+// handlers add domain metrics per category:
+//
+//	requests  API calls (endpoint)
+//	errors    API calls that failed with a 5xx status (endpoint)
+//	ideas     generated ideas (category)
+//	like      likes (category)
+//	dislike   dislikes (category)
+//	premium   users who got a paid tier (category = grant source)
+//	stars     Telegram Stars received (category = product)
+//	ton       assets paid in TON (category = asset)
+
+// statsMiddleware counts API requests and the active users behind them.
+func statsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		path := c.FullPath()
+		if !strings.HasPrefix(path, "/api/") || c.Request.Method == http.MethodOptions {
+			return
+		}
+		recordStat("requests", path, "")
+		if c.Writer.Status() >= 500 {
+			recordStat("errors", path, "")
+		}
+		if userID := scopeFromContext(c.Request.Context()).UserID; userID != "" {
+			if err := db.AddActiveUser(userID); err != nil {
+				log.Printf("Stats error: %v", err)
+			}
+		}
+	}
+}
+
+func recordStat(metric, endpoint, category string) {
+	if err := db.AddStat(metric, endpoint, category, 1); err != nil {
+		log.Printf("Stats error: %v", err)
+	}
+}
+
+// statsRange turns ?range= (today, 7d, 30d, all; default 30d) or explicit
+// ?from=&to= days into an inclusive day range.
+func statsRange(c *gin.Context) (store.StatsFilter, error) {
+	now := time.Now().UTC()
+	f := store.StatsFilter{From: c.Query("from"), To: c.Query("to")}
+	if f.From != "" || f.To != "" {
+		for _, day := range []string{f.From, f.To} {
+			if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+				return f, fmt.Errorf("from and to must be YYYY-MM-DD days")
+			}
+		}
+		return f, nil
+	}
+
+	switch r := c.DefaultQuery("range", "30d"); r {
+	case "all":
+	case "today":
+		f.From = now.Format("2006-01-02")
+	default:
+		days, err := strconv.Atoi(strings.TrimSuffix(r, "d"))
+		if !strings.HasSuffix(r, "d") || err != nil || days <= 0 {
+			return f, fmt.Errorf("range must be today, all or a number of days like 7d")
+		}
+		f.From = now.AddDate(0, 0, 1-days).Format("2006-01-02")
+	}
+	return f, nil
+}
+
+type statsGroup struct {
+	Key         string           `json:"key"`
+	Metrics     map[string]int64 `json:"metrics"`
+	UniqueUsers *int             `json:"unique_users,omitempty"`
+}
+
+// getStats reports the counters over a range, optionally grouped by day,
+// endpoint, category or metric. "count" stays the all-time number of
+// generated ideas the frontends show.
+func getStats(c *gin.Context) {
+	f, err := statsRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	groupBy := c.Query("group_by")
+	var keyOf func(store.Stat) string
+	switch groupBy {
+	case "":
+	case "day":
+		keyOf = func(s store.Stat) string { return s.Day }
+	case "endpoint":
+		keyOf = func(s store.Stat) string { return s.Endpoint }
+	case "category":
+		keyOf = func(s store.Stat) string { return s.Category }
+	case "metric":
+		keyOf = func(s store.Stat) string { return s.Metric }
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be one of day, endpoint, category, metric"})
+		return
+	}
+	f.Metric = c.Query("metric")
+
+	count, err := db.Counter("ideas_generated")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stats, err := db.ListStats(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	users, dailyUsers, err := db.ActiveUsers(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals := map[string]int64{}
+	groups := map[string]*statsGroup{}
+	for _, s := range stats {
+		totals[s.Metric] += s.Count
+		if keyOf == nil || keyOf(s) == "" {
+			continue
+		}
+		g, ok := groups[keyOf(s)]
+		if !ok {
+			g = &statsGroup{Key: keyOf(s), Metrics: map[string]int64{}}
+			groups[g.Key] = g
+		}
+		g.Metrics[s.Metric] += s.Count
+	}
+	if groupBy == "day" {
+		for day, n := range dailyUsers {
+			g, ok := groups[day]
+			if !ok {
+				g = &statsGroup{Key: day, Metrics: map[string]int64{}}
+				groups[day] = g
+			}
+			n := n
+			g.UniqueUsers = &n
+		}
+	}
+	list := make([]statsGroup, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, *g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	conversion := 0.0
+	if users > 0 {
+		conversion = math.Round(float64(totals["premium"])/float64(users)*10000) / 10000
+	}
+	resp := gin.H{
+		"count":              count,
+		"from":               f.From,
+		"to":                 f.To,
+		"totals":             totals,
+		"unique_users":       users,
+		"premium_conversion": conversion,
+	}
+	if keyOf != nil {
+		resp["group_by"] = groupBy
+		resp["groups"] = list
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Asset is an item of the marketplace catalog. Price is in whole units of
+// Currency (TON, not nanotons).
+type Asset struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Price       float64   `json:"price"`
+	Currency    string    `json:"currency"`
+	Category    string    `json:"category"`
+	Format      string    `json:"format"`
+	Author      string    `json:"author"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// AssetFilter narrows ListAssets. Zero values match everything; the price
+// bounds are inclusive and ignored when nil. Limit defaults to 20 and is
+// capped at 100.
+type AssetFilter struct {
+	Category string
+	Currency string
+	Status   string
+	MinPrice *float64
+	MaxPrice *float64
+	Limit    int
+	Offset   int
+}
+
+func (f AssetFilter) where() (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.Category != "" {
+		where = append(where, "category = ? COLLATE NOCASE")
+		args = append(args, f.Category)
+	}
+	if f.Currency != "" {
+		where = append(where, "currency = ?")
+		args = append(args, f.Currency)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.MinPrice != nil {
+		where = append(where, "price >= ?")
+		args = append(args, *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		where = append(where, "price <= ?")
+		args = append(args, *f.MaxPrice)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (f AssetFilter) limit() int {
+	switch {
+	case f.Limit <= 0:
+		return 20
+	case f.Limit > 100:
+		return 100
+	}
+	return f.Limit
+}
+
+const assetColumns = `id, name, description, price, currency, category, format, author, status, created_at, updated_at`
+
+func scanAsset(row interface{ Scan(...interface{}) error }) (Asset, error) {
+	var a Asset
+	var created, updated int64
+	err := row.Scan(&a.ID, &a.Name, &a.Description, &a.Price, &a.Currency, &a.Category, &a.Format, &a.Author,
+		&a.Status, &created, &updated)
+	a.CreatedAt, a.UpdatedAt = fromUnix(created), fromUnix(updated)
+	return a, err
+}
+
+// ListAssets returns a page of matching assets, oldest first, and how many
+// match in total.
+func (s *Store) ListAssets(f AssetFilter) ([]Asset, int, error) {
+	cond, args := f.where()
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM assets`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.Query(`SELECT `+assetColumns+` FROM assets`+cond+` ORDER BY created_at, id LIMIT ? OFFSET ?`,
+		append(args, f.limit(), f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []Asset{}
+	for rows.Next() {
+		a, err := scanAsset(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, a)
+	}
+	return list, total, rows.Err()
+}
+
+func (s *Store) GetAsset(id string) (Asset, error) {
+	a, err := scanAsset(s.db.QueryRow(`SELECT `+assetColumns+` FROM assets WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+// CreateAsset adds an asset; it returns ErrExists when the id is taken.
+func (s *Store) CreateAsset(a *Asset) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = now
+	}
+	a.UpdatedAt = now
+	_, err := s.db.Exec(`INSERT INTO assets (`+assetColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.Name, a.Description, a.Price, a.Currency, a.Category, a.Format, a.Author, a.Status,
+		a.CreatedAt.Unix(), a.UpdatedAt.Unix())
+	var se sqlite3.Error
+	if errors.As(err, &se) && se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return ErrExists
+	}
+	return err
+}
+
+// UpdateAsset replaces every field of an existing asset but its id and
+// creation time.
+func (s *Store) UpdateAsset(a *Asset) error {
+	a.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	var created int64
+	err := s.db.QueryRow(`UPDATE assets SET name = ?, description = ?, price = ?, currency = ?, category = ?,
+		format = ?, author = ?, status = ?, updated_at = ? WHERE id = ? RETURNING created_at`,
+		a.Name, a.Description, a.Price, a.Currency, a.Category, a.Format, a.Author, a.Status, a.UpdatedAt.Unix(), a.ID).
+		Scan(&created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	a.CreatedAt = fromUnix(created)
+	return err
+}
+
+func (s *Store) DeleteAsset(id string) error {
+	res, err := s.db.Exec(`DELETE FROM assets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Email is a rendered email together with the EmailRequest JSON it came from.
+type Email struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Type      string    `json:"type"`
+	Subject   string    `json:"subject"`
+	Request   string    `json:"request"`
+	HTML      string    `json:"html"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Store) SaveEmail(e *Email) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.db.Exec(`INSERT INTO emails (id, user_id, type, subject, request, html, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.UserID, e.Type, e.Subject, e.Request, e.HTML, e.CreatedAt.Unix())
+	return err
+}
+
+func (s *Store) GetEmail(id string) (Email, error) {
+	e := Email{ID: id}
+	var created int64
+	err := s.db.QueryRow(`SELECT user_id, type, subject, request, html, created_at FROM emails WHERE id = ?`, id).
+		Scan(&e.UserID, &e.Type, &e.Subject, &e.Request, &e.HTML, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, ErrNotFound
+	}
+	e.CreatedAt = fromUnix(created)
+	return e, err
+}
+
+// Upload is an image stored under the uploads directory.
+type Upload struct {
+	Filename     string    `json:"filename"`
+	OriginalName string    `json:"original_name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (s *Store) AddUpload(u *Upload) error {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.db.Exec(`INSERT INTO uploads (filename, original_name, content_type, size, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		u.Filename, u.OriginalName, u.ContentType, u.Size, u.CreatedAt.Unix())
+	return err
+}
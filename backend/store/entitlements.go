@@ -0,0 +1,140 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// FeatureGrant unlocks a single feature, independent of the user's tier.
+// A nil ExpiresAt never expires.
+type FeatureGrant struct {
+	UserID    string     `json:"user_id"`
+	Feature   string     `json:"feature"`
+	Source    string     `json:"source"`
+	GrantedAt time.Time  `json:"granted_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Active reports whether the grant has not expired.
+func (g FeatureGrant) Active(now time.Time) bool {
+	return g.ExpiresAt == nil || now.Before(*g.ExpiresAt)
+}
+
+// GrantFeature unlocks a feature for a user, replacing an earlier grant.
+func (s *Store) GrantFeature(userID, feature, source string, expiresAt *time.Time) error {
+	var expires sql.NullInt64
+	if expiresAt != nil {
+		expires = sql.NullInt64{Int64: expiresAt.Unix(), Valid: true}
+	}
+	if err := s.TouchUser(userID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO feature_grants (user_id, feature, source, granted_at, expires_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, feature) DO UPDATE SET source = excluded.source, granted_at = excluded.granted_at,
+			expires_at = excluded.expires_at`,
+		userID, feature, source, time.Now().Unix(), expires)
+	return err
+}
+
+// FeatureGrants returns the user's grants, expired ones included.
+func (s *Store) FeatureGrants(userID string) ([]FeatureGrant, error) {
+	rows, err := s.db.Query(`SELECT feature, source, granted_at, expires_at FROM feature_grants
+		WHERE user_id = ? ORDER BY feature`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []FeatureGrant
+	for rows.Next() {
+		g := FeatureGrant{UserID: userID}
+		var granted int64
+		var expires sql.NullInt64
+		if err := rows.Scan(&g.Feature, &g.Source, &granted, &expires); err != nil {
+			return nil, err
+		}
+		g.GrantedAt = fromUnix(granted)
+		if expires.Valid {
+			t := fromUnix(expires.Int64)
+			g.ExpiresAt = &t
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// Credits returns the user's credit balance per feature.
+func (s *Store) Credits(userID string) (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT feature, balance FROM credits WHERE user_id = ? AND balance > 0`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]int64)
+	for rows.Next() {
+		var feature string
+		var balance int64
+		if err := rows.Scan(&feature, &balance); err != nil {
+			return nil, err
+		}
+		out[feature] = balance
+	}
+	return out, rows.Err()
+}
+
+// AddCredits adds n credits (n may be negative) and logs the change with a
+// reason such as "stars" or "refund". It returns the new balance.
+func (s *Store) AddCredits(userID, feature string, n int64, reason string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	balance, err := addCredits(tx, userID, feature, n, reason)
+	if err != nil {
+		return 0, err
+	}
+	return balance, tx.Commit()
+}
+
+func addCredits(tx *sql.Tx, userID, feature string, n int64, reason string) (int64, error) {
+	if err := touchUser(tx, userID); err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	var balance int64
+	err := tx.QueryRow(`INSERT INTO credits (user_id, feature, balance, updated_at) VALUES (?, ?, MAX(?, 0), ?)
+		ON CONFLICT (user_id, feature) DO UPDATE SET balance = MAX(credits.balance + ?, 0),
+			updated_at = excluded.updated_at
+		RETURNING balance`, userID, feature, n, now, n).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`INSERT INTO credit_events (user_id, feature, delta, reason, created_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, feature, n, reason, now); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// UseCredit spends one credit. It reports false when the balance is empty.
+func (s *Store) UseCredit(userID, feature string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	res, err := tx.Exec(`UPDATE credits SET balance = balance - 1, updated_at = ?
+		WHERE user_id = ? AND feature = ? AND balance > 0`, now, userID, feature)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO credit_events (user_id, feature, delta, reason, created_at) VALUES (?, ?, -1, 'use', ?)`,
+		userID, feature, now); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Idea is a generated idea: the generator's draft and the critic-refined text.
+type Idea struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Category  string    `json:"category"`
+	Raw       string    `json:"raw,omitempty"`
+	Text      string    `json:"idea"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Store) AddIdea(i *Idea) error {
+	if i.CreatedAt.IsZero() {
+		i.CreatedAt = time.Now().UTC()
+	}
+	res, err := s.db.Exec(`INSERT INTO ideas (user_id, category, raw, text, created_at) VALUES (?, ?, ?, ?, ?)`,
+		i.UserID, i.Category, i.Raw, i.Text, i.CreatedAt.Unix())
+	if err != nil {
+		return err
+	}
+	i.ID, err = res.LastInsertId()
+	return err
+}
+
+func (s *Store) GetIdea(id int64) (Idea, error) {
+	i := Idea{ID: id}
+	var created int64
+	err := s.db.QueryRow(`SELECT user_id, category, raw, text, created_at FROM ideas WHERE id = ?`, id).
+		Scan(&i.UserID, &i.Category, &i.Raw, &i.Text, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrNotFound
+	}
+	i.CreatedAt = fromUnix(created)
+	return i, err
+}
+
+// Feedback is a like/dislike left on an idea. IdeaID is nil for feedback on
+// ideas that were not stored (older clients only send the text).
+type Feedback struct {
+	ID        int64     `json:"id"`
+	IdeaID    *int64    `json:"idea_id,omitempty"`
+	IdeaText  string    `json:"idea"`
+	Category  string    `json:"category,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Value     string    `json:"feedback"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddFeedback stores a vote. A user voting again on the same idea replaces
+// their earlier vote.
+func (s *Store) AddFeedback(f *Feedback) error {
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now().UTC()
+	}
+	return s.db.QueryRow(`INSERT INTO feedback (idea_id, idea_text, category, user_id, value, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (idea_id, user_id) WHERE user_id != '' AND idea_id IS NOT NULL
+		DO UPDATE SET value = excluded.value, created_at = excluded.created_at
+		RETURNING id`,
+		f.IdeaID, f.IdeaText, f.Category, f.UserID, f.Value, f.CreatedAt.Unix()).Scan(&f.ID)
+}
+
+// FindIdeaByText returns the most recent idea with exactly this text, for
+// clients that send feedback without an idea id.
+func (s *Store) FindIdeaByText(text string) (Idea, error) {
+	var id int64
+	err := s.db.QueryRow(`SELECT id FROM ideas WHERE text = ? ORDER BY id DESC LIMIT 1`, text).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Idea{}, ErrNotFound
+	}
+	if err != nil {
+		return Idea{}, err
+	}
+	return s.GetIdea(id)
+}
+
+// RatedIdea is an idea with its feedback tally; Score is likes minus dislikes.
+type RatedIdea struct {
+	Idea
+	Likes    int `json:"likes"`
+	Dislikes int `json:"dislikes"`
+	Score    int `json:"score"`
+}
+
+// RatedIdeas returns the best (best=true) or worst rated ideas, optionally
+// limited to one category. Only ideas with a positive (or negative) score
+// are included.
+func (s *Store) RatedIdeas(category string, best bool, limit int) ([]RatedIdea, error) {
+	query := `SELECT i.id, i.user_id, i.category, i.raw, i.text, i.created_at,
+			SUM(f.value = 'like') AS likes, SUM(f.value = 'dislike') AS dislikes
+		FROM ideas i JOIN feedback f ON f.idea_id = i.id
+		WHERE (? = '' OR i.category = ?)
+		GROUP BY i.id`
+	if best {
+		query += ` HAVING likes > dislikes ORDER BY likes - dislikes DESC, likes DESC, i.id DESC`
+	} else {
+		query += ` HAVING dislikes > likes ORDER BY likes - dislikes ASC, dislikes DESC, i.id DESC`
+	}
+	query += ` LIMIT ?`
+
+	rows, err := s.db.Query(query, category, category, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []RatedIdea{}
+	for rows.Next() {
+		var r RatedIdea
+		var created int64
+		if err := rows.Scan(&r.ID, &r.UserID, &r.Category, &r.Raw, &r.Text, &created, &r.Likes, &r.Dislikes); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = fromUnix(created)
+		r.Score = r.Likes - r.Dislikes
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// IdeaFilter narrows ListIdeas and SearchIdeas. Zero values match everything;
+// Limit defaults to 20 and is capped at 100.
+type IdeaFilter struct {
+	UserID   string
+	Category string
+	Limit    int
+	Offset   int
+}
+
+func (f IdeaFilter) where(prefix string) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.UserID != "" {
+		where = append(where, prefix+"user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Category != "" {
+		where = append(where, prefix+"category = ?")
+		args = append(args, f.Category)
+	}
+	return where, args
+}
+
+func (f IdeaFilter) limit() int {
+	switch {
+	case f.Limit <= 0:
+		return 20
+	case f.Limit > 100:
+		return 100
+	}
+	return f.Limit
+}
+
+func ideaColumns(prefix string) string {
+	return prefix + "id, " + prefix + "user_id, " + prefix + "category, " + prefix + "raw, " + prefix + "text, " + prefix + "created_at"
+}
+
+func (s *Store) queryIdeas(query string, args ...interface{}) ([]Idea, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Idea{}
+	for rows.Next() {
+		var i Idea
+		var created int64
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Category, &i.Raw, &i.Text, &created); err != nil {
+			return nil, err
+		}
+		i.CreatedAt = fromUnix(created)
+		list = append(list, i)
+	}
+	return list, rows.Err()
+}
+
+// ListIdeas returns ideas newest first, with the total number matching f
+// for pagination.
+func (s *Store) ListIdeas(f IdeaFilter) ([]Idea, int, error) {
+	where, args := f.where("")
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM ideas`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	list, err := s.queryIdeas(`SELECT `+ideaColumns("")+` FROM ideas`+cond+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, f.limit(), f.Offset)...)
+	return list, total, err
+}
@@ -0,0 +1,29 @@
+package store
+
+import "time"
+
+// JobRun records one LLM job of a multi-step workflow, e.g. a supervisor
+// specialist.
+type JobRun struct {
+	ID         int64     `json:"id"`
+	UserID     string    `json:"user_id"`
+	Route      string    `json:"route"`
+	Specialist string    `json:"specialist"`
+	Status     string    `json:"status"` // "ok" or "error"
+	Error      string    `json:"error,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+func (s *Store) AddJobRun(r *JobRun) error {
+	res, err := s.db.Exec(`INSERT INTO job_runs (user_id, route, specialist, status, error, provider, model, duration_ms, started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.UserID, r.Route, r.Specialist, r.Status, r.Error, r.Provider, r.Model, r.DurationMS, r.StartedAt.Unix())
+	if err != nil {
+		return err
+	}
+	r.ID, err = res.LastInsertId()
+	return err
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	PaymentPending = "pending"
+	PaymentPaid    = "paid"
+)
+
+// Payment is an invoice sent to a user. Payload is the invoice_payload that
+// Telegram echoes back in pre_checkout_query and successful_payment.
+type Payment struct {
+	Payload          string     `json:"payload"`
+	UserID           string     `json:"user_id"`
+	Product          string     `json:"product"`
+	Currency         string     `json:"currency"`
+	Amount           int64      `json:"amount"`
+	Status           string     `json:"status"`
+	ChargeID         string     `json:"charge_id,omitempty"`
+	ProviderChargeID string     `json:"provider_charge_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	PaidAt           *time.Time `json:"paid_at,omitempty"`
+}
+
+// CreatePayment stores a new pending invoice.
+func (s *Store) CreatePayment(p *Payment) error {
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now().UTC()
+	}
+	p.Status = PaymentPending
+	_, err := s.db.Exec(`INSERT INTO payments (payload, user_id, product, currency, amount, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.Payload, p.UserID, p.Product, p.Currency, p.Amount, p.Status, p.CreatedAt.Unix())
+	return err
+}
+
+func (s *Store) GetPayment(payload string) (Payment, error) {
+	p := Payment{Payload: payload}
+	var created int64
+	var charge sql.NullString
+	var paid sql.NullInt64
+	err := s.db.QueryRow(`SELECT user_id, product, currency, amount, status, charge_id, provider_charge_id, created_at, paid_at
+		FROM payments WHERE payload = ?`, payload).
+		Scan(&p.UserID, &p.Product, &p.Currency, &p.Amount, &p.Status, &charge, &p.ProviderChargeID, &created, &paid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	if err != nil {
+		return p, err
+	}
+	p.ChargeID = charge.String
+	p.CreatedAt = fromUnix(created)
+	if paid.Valid {
+		t := fromUnix(paid.Int64)
+		p.PaidAt = &t
+	}
+	return p, nil
+}
+
+// ClaimPayment marks a pending payment paid and runs fulfil in the same
+// transaction, so the charge is recorded if and only if fulfil succeeds.
+// It reports false without calling fulfil when the payment is not pending,
+// so redelivered updates grant nothing.
+func (s *Store) ClaimPayment(payload, chargeID, providerChargeID string, fulfil func(*Tx) error) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE payments SET status = ?, charge_id = ?, provider_charge_id = ?, paid_at = ?
+		WHERE payload = ? AND status = ?`,
+		PaymentPaid, chargeID, providerChargeID, time.Now().Unix(), payload, PaymentPending)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := fulfil(&Tx{tx: tx}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
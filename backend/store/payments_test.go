@@ -0,0 +1,49 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClaimPayment(t *testing.T) {
+	s := openTest(t)
+	p := Payment{Payload: "stars_1", UserID: "42", Product: "outreach-drafter", Currency: "XTR", Amount: 10}
+	if err := s.CreatePayment(&p); err != nil {
+		t.Fatal(err)
+	}
+	grant := func(tx *Tx) error {
+		_, err := tx.AddCredits("42", "outreach-drafter", 1, "stars")
+		return err
+	}
+
+	// A failed fulfilment rolls the claim back
+	boom := errors.New("boom")
+	if _, err := s.ClaimPayment(p.Payload, "ch_1", "", func(tx *Tx) error {
+		if err := grant(tx); err != nil {
+			return err
+		}
+		return boom
+	}); !errors.Is(err, boom) {
+		t.Fatalf("ClaimPayment = %v, want %v", err, boom)
+	}
+	if got, _ := s.GetPayment(p.Payload); got.Status != PaymentPending {
+		t.Fatalf("status after failure = %s, want pending", got.Status)
+	}
+	if c, _ := s.Credits("42"); c["outreach-drafter"] != 0 {
+		t.Fatalf("credits after failure = %d, want 0", c["outreach-drafter"])
+	}
+
+	for i, want := range []bool{true, false} {
+		claimed, err := s.ClaimPayment(p.Payload, "ch_1", "", grant)
+		if err != nil || claimed != want {
+			t.Fatalf("claim %d = %v, %v, want %v", i, claimed, err, want)
+		}
+	}
+	got, _ := s.GetPayment(p.Payload)
+	if got.Status != PaymentPaid || got.ChargeID != "ch_1" {
+		t.Errorf("payment = %+v, want paid with ch_1", got)
+	}
+	if c, _ := s.Credits("42"); c["outreach-drafter"] != 1 {
+		t.Errorf("credits = %d, want 1", c["outreach-drafter"])
+	}
+}
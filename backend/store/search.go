@@ -0,0 +1,100 @@
+package store
+
+import (
+	"strings"
+	"unicode"
+)
+
+// setupSearch creates the FTS5 index over ideas when SQLite was built with
+// FTS5 (go build -tags sqlite_fts5). Without it, search falls back to LIKE
+// and the sync triggers are dropped so inserts keep working; the index is
+// rebuilt the next time an FTS5 build opens the database.
+func (s *Store) setupSearch() {
+	// CREATE VIRTUAL TABLE IF NOT EXISTS succeeds on an existing table even
+	// without the module, so ask SQLite how it was compiled.
+	var enabled bool
+	s.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled)
+	if !enabled {
+		s.fts = false
+		s.db.Exec(`DROP TRIGGER IF EXISTS ideas_fts_ai; DROP TRIGGER IF EXISTS ideas_fts_ad; DROP TRIGGER IF EXISTS ideas_fts_au;`)
+		return
+	}
+
+	_, err := s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS ideas_fts USING fts5(
+		text, raw, content='ideas', content_rowid='id', tokenize='unicode61'
+	)`)
+	if err != nil {
+		return
+	}
+
+	var triggers int
+	s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'ideas_fts_%'`).Scan(&triggers)
+	if triggers < 3 {
+		_, err = s.db.Exec(`
+			CREATE TRIGGER IF NOT EXISTS ideas_fts_ai AFTER INSERT ON ideas BEGIN
+				INSERT INTO ideas_fts (rowid, text, raw) VALUES (new.id, new.text, new.raw);
+			END;
+			CREATE TRIGGER IF NOT EXISTS ideas_fts_ad AFTER DELETE ON ideas BEGIN
+				INSERT INTO ideas_fts (ideas_fts, rowid, text, raw) VALUES ('delete', old.id, old.text, old.raw);
+			END;
+			CREATE TRIGGER IF NOT EXISTS ideas_fts_au AFTER UPDATE ON ideas BEGIN
+				INSERT INTO ideas_fts (ideas_fts, rowid, text, raw) VALUES ('delete', old.id, old.text, old.raw);
+				INSERT INTO ideas_fts (rowid, text, raw) VALUES (new.id, new.text, new.raw);
+			END;
+			INSERT INTO ideas_fts (ideas_fts) VALUES ('rebuild');`)
+	}
+	s.fts = err == nil
+}
+
+// SearchMode reports how SearchIdeas matches: "fts5" or "like".
+func (s *Store) SearchMode() string {
+	if s.fts {
+		return "fts5"
+	}
+	return "like"
+}
+
+// searchTerms splits a user query into plain words, dropping FTS5 syntax.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchIdeas finds ideas containing all words of query (prefix matches
+// with FTS5), best matches first. Filter fields narrow the search.
+func (s *Store) SearchIdeas(query string, f IdeaFilter) ([]Idea, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []Idea{}, nil
+	}
+	where, args := f.where("i.")
+
+	var sql string
+	if s.fts {
+		quoted := make([]string, len(terms))
+		for i, t := range terms {
+			quoted[i] = `"` + t + `"*`
+		}
+		sql = `SELECT ` + ideaColumns("i.") + ` FROM ideas_fts JOIN ideas i ON i.id = ideas_fts.rowid
+			WHERE ideas_fts MATCH ?`
+		args = append([]interface{}{strings.Join(quoted, " ")}, args...)
+		for _, w := range where {
+			sql += " AND " + w
+		}
+		sql += ` ORDER BY bm25(ideas_fts), i.id DESC`
+	} else {
+		var likes []string
+		var likeArgs []interface{}
+		for _, t := range terms {
+			likes = append(likes, `(unicode_lower(i.text) LIKE ? OR unicode_lower(i.raw) LIKE ?)`)
+			likeArgs = append(likeArgs, "%"+t+"%", "%"+t+"%")
+		}
+		sql = `SELECT ` + ideaColumns("i.") + ` FROM ideas i WHERE ` + strings.Join(append(likes, where...), " AND ")
+		args = append(likeArgs, args...)
+		sql += ` ORDER BY i.id DESC`
+	}
+	sql += ` LIMIT ? OFFSET ?`
+	args = append(args, f.limit(), f.Offset)
+	return s.queryIdeas(sql, args...)
+}
@@ -0,0 +1,13 @@
+//go:build sqlite_fts5
+
+package store
+
+import "testing"
+
+func TestSearchIdeasFTS5(t *testing.T) {
+	s := openTest(t)
+	if s.SearchMode() != "fts5" {
+		t.Fatalf("search mode %q in an sqlite_fts5 build", s.SearchMode())
+	}
+	testSearch(t, s)
+}
@@ -0,0 +1,45 @@
+package store
+
+import "testing"
+
+// testSearch runs in both search modes: plain builds use LIKE, builds with
+// -tags sqlite_fts5 use the FTS5 index (see search_fts5_test.go).
+func testSearch(t *testing.T, s *Store) {
+	t.Helper()
+	for _, idea := range []Idea{
+		{Category: "business", Text: "Ёжик продаёт Кофе у метро"},
+		{Category: "business", Raw: "PSX Hedgehog draft", Text: "Low-poly hedgehog pack"},
+		{Category: "creative", Text: "Чайная для разработчиков"},
+	} {
+		if err := s.AddIdea(&idea); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"ёжик", 1},
+		{"Ёжик", 1},
+		{"ЁЖИК кофе", 1},
+		{"ёжик чай", 0},
+		{"hedgehog", 1},
+		{"psx", 1},
+		{"!!", 0},
+	} {
+		got, err := s.SearchIdeas(tc.query, IdeaFilter{})
+		if err != nil {
+			t.Fatalf("%s: %q: %v", s.SearchMode(), tc.query, err)
+		}
+		if len(got) != tc.want {
+			t.Errorf("%s: %q found %d ideas, want %d", s.SearchMode(), tc.query, len(got), tc.want)
+		}
+	}
+	if got, _ := s.SearchIdeas("ёжик", IdeaFilter{Category: "creative"}); len(got) != 0 {
+		t.Errorf("%s: category filter ignored: %d ideas", s.SearchMode(), len(got))
+	}
+}
+
+func TestSearchIdeas(t *testing.T) {
+	testSearch(t, openTest(t))
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ChatSession is a server-side conversation owned by one user. Messages
+// before SummarizedCount are folded into Summary.
+type ChatSession struct {
+	ID              string        `json:"id"`
+	UserID          string        `json:"user_id"`
+	Title           string        `json:"title"`
+	SystemPrompt    string        `json:"system_prompt,omitempty"`
+	Summary         string        `json:"summary,omitempty"`
+	SummarizedCount int           `json:"summarized_count,omitempty"`
+	Messages        []ChatMessage `json:"messages"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+type ChatMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SessionInfo is the list view of a session, without its messages.
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	Title        string    `json:"title"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CreateSession inserts a session together with any messages it carries.
+func (s *Store) CreateSession(cs *ChatSession) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO chat_sessions (id, user_id, title, system_prompt, summary, summarized_count, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		cs.ID, cs.UserID, cs.Title, cs.SystemPrompt, cs.Summary, cs.SummarizedCount,
+		cs.CreatedAt.Unix(), cs.UpdatedAt.Unix()); err != nil {
+		return err
+	}
+	if err := insertMessages(tx, cs.ID, 0, cs.Messages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertMessages(tx *sql.Tx, sessionID string, seq int, messages []ChatMessage) error {
+	for i, m := range messages {
+		if _, err := tx.Exec(`INSERT INTO chat_messages (session_id, seq, role, content, created_at) VALUES (?, ?, ?, ?, ?)`,
+			sessionID, seq+i, m.Role, m.Content, m.CreatedAt.Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSession loads a session with all its messages.
+func (s *Store) GetSession(id string) (ChatSession, error) {
+	cs := ChatSession{ID: id, Messages: []ChatMessage{}}
+	var created, updated int64
+	err := s.db.QueryRow(`SELECT user_id, title, system_prompt, summary, summarized_count, created_at, updated_at
+		FROM chat_sessions WHERE id = ?`, id).
+		Scan(&cs.UserID, &cs.Title, &cs.SystemPrompt, &cs.Summary, &cs.SummarizedCount, &created, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return cs, ErrNotFound
+	}
+	if err != nil {
+		return cs, err
+	}
+	cs.CreatedAt, cs.UpdatedAt = fromUnix(created), fromUnix(updated)
+
+	rows, err := s.db.Query(`SELECT role, content, created_at FROM chat_messages WHERE session_id = ? ORDER BY seq`, id)
+	if err != nil {
+		return cs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m ChatMessage
+		var at int64
+		if err := rows.Scan(&m.Role, &m.Content, &at); err != nil {
+			return cs, err
+		}
+		m.CreatedAt = fromUnix(at)
+		cs.Messages = append(cs.Messages, m)
+	}
+	return cs, rows.Err()
+}
+
+// ListSessions returns a user's sessions, most recently active first.
+func (s *Store) ListSessions(userID string) ([]SessionInfo, error) {
+	rows, err := s.db.Query(`SELECT cs.id, cs.title, cs.created_at, cs.updated_at,
+			(SELECT COUNT(*) FROM chat_messages m WHERE m.session_id = cs.id)
+		FROM chat_sessions cs WHERE cs.user_id = ? ORDER BY cs.updated_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []SessionInfo{}
+	for rows.Next() {
+		info := SessionInfo{UserID: userID}
+		var created, updated int64
+		if err := rows.Scan(&info.ID, &info.Title, &created, &updated, &info.MessageCount); err != nil {
+			return nil, err
+		}
+		info.CreatedAt, info.UpdatedAt = fromUnix(created), fromUnix(updated)
+		list = append(list, info)
+	}
+	return list, rows.Err()
+}
+
+// SessionUpdate is appended to a session after a chat turn.
+type SessionUpdate struct {
+	Messages []ChatMessage
+	// Summary replaces the stored summary when SummarizedCount grows.
+	Summary         string
+	SummarizedCount int
+	// Title is set only if the session has none yet.
+	Title string
+}
+
+func (s *Store) AppendToSession(id string, u SessionUpdate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count, summarized int
+	err = tx.QueryRow(`SELECT summarized_count, (SELECT COUNT(*) FROM chat_messages WHERE session_id = ?)
+		FROM chat_sessions WHERE id = ?`, id, id).Scan(&summarized, &count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := insertMessages(tx, id, count, u.Messages); err != nil {
+		return err
+	}
+	if u.SummarizedCount > summarized && u.SummarizedCount <= count+len(u.Messages) {
+		if _, err := tx.Exec(`UPDATE chat_sessions SET summary = ?, summarized_count = ? WHERE id = ?`,
+			u.Summary, u.SummarizedCount, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE chat_sessions SET updated_at = ?, title = CASE WHEN title = '' THEN ? ELSE title END
+		WHERE id = ?`, time.Now().Unix(), u.Title, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) DeleteSession(id string) error {
+	_, err := s.db.Exec(`DELETE FROM chat_sessions WHERE id = ?`, id)
+	return err
+}
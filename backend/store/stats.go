@@ -0,0 +1,108 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// Stat is one daily counter. Endpoint is set for request metrics, Category
+// for domain metrics such as ideas (idea category) or premium (grant source).
+type Stat struct {
+	Day      string `json:"day"`
+	Metric   string `json:"metric"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Category string `json:"category,omitempty"`
+	Count    int64  `json:"count"`
+}
+
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// AddStat adds n to today's counter for metric, endpoint and category.
+func (s *Store) AddStat(metric, endpoint, category string, n int64) error {
+	_, err := s.db.Exec(`INSERT INTO stats_daily (day, metric, endpoint, category, count) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (day, metric, endpoint, category) DO UPDATE SET count = count + excluded.count`,
+		today(), metric, endpoint, category, n)
+	return err
+}
+
+// AddActiveUser marks userID as active today, for unique user counts.
+func (s *Store) AddActiveUser(userID string) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO stats_users (day, user_id) VALUES (?, ?)`, today(), userID)
+	return err
+}
+
+// StatsFilter narrows ListStats and ActiveUsers; From and To are inclusive
+// YYYY-MM-DD days, empty fields match everything.
+type StatsFilter struct {
+	From   string
+	To     string
+	Metric string
+}
+
+func (f StatsFilter) where() (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.From != "" {
+		where = append(where, "day >= ?")
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		where = append(where, "day <= ?")
+		args = append(args, f.To)
+	}
+	if f.Metric != "" {
+		where = append(where, "metric = ?")
+		args = append(args, f.Metric)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (s *Store) ListStats(f StatsFilter) ([]Stat, error) {
+	cond, args := f.where()
+	rows, err := s.db.Query(`SELECT day, metric, endpoint, category, count FROM stats_daily`+cond+
+		` ORDER BY day, metric, endpoint, category`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Stat{}
+	for rows.Next() {
+		var st Stat
+		if err := rows.Scan(&st.Day, &st.Metric, &st.Endpoint, &st.Category, &st.Count); err != nil {
+			return nil, err
+		}
+		list = append(list, st)
+	}
+	return list, rows.Err()
+}
+
+// ActiveUsers returns the number of distinct users in the range and the
+// number of users active on each day.
+func (s *Store) ActiveUsers(f StatsFilter) (int, map[string]int, error) {
+	f.Metric = ""
+	cond, args := f.where()
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(DISTINCT user_id) FROM stats_users`+cond, args...).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+	rows, err := s.db.Query(`SELECT day, COUNT(*) FROM stats_users`+cond+` GROUP BY day`, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	daily := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			return 0, nil, err
+		}
+		daily[day] = n
+	}
+	return total, daily, rows.Err()
+}
@@ -0,0 +1,394 @@
+// Package store is the SQLite persistence layer: users, premium, ideas,
+// feedback, emails, uploads, job runs, LLM usage and chat sessions.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is go-sqlite3 with the functions the store adds.
+const driverName = "sqlite3_store"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// SQLite's LOWER only folds ASCII; search needs Cyrillic too
+			return conn.RegisterFunc("unicode_lower", strings.ToLower, true)
+		},
+	})
+}
+
+// ErrNotFound is returned when a looked-up row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when creating a row whose key is taken.
+var ErrExists = errors.New("already exists")
+
+type Store struct {
+	db  *sql.DB
+	fts bool
+}
+
+// Open opens (or creates) the database at path and applies pending
+// migrations. Use ":memory:" for a throwaway database.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open(driverName, path+"?_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids "database is
+	// locked" errors and keeps :memory: databases consistent.
+	db.SetMaxOpenConns(1)
+	if path != ":memory:" {
+		db.Exec(`PRAGMA journal_mode=WAL`)
+	}
+
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.setupSearch()
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping reports whether the database is reachable.
+func (s *Store) Ping() error {
+	return s.db.Ping()
+}
+
+// migrations are applied in order; never edit one that has shipped, add a
+// new one instead.
+var migrations = []string{
+	// 1: initial schema
+	`CREATE TABLE users (
+		id           TEXT PRIMARY KEY,
+		created_at   INTEGER NOT NULL,
+		last_seen_at INTEGER NOT NULL
+	);
+	CREATE TABLE premium (
+		user_id    TEXT PRIMARY KEY,
+		source     TEXT NOT NULL,
+		granted_at INTEGER NOT NULL,
+		expires_at INTEGER
+	);
+	CREATE TABLE ideas (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    TEXT NOT NULL DEFAULT '',
+		category   TEXT NOT NULL,
+		raw        TEXT NOT NULL DEFAULT '',
+		text       TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX ideas_category ON ideas (category, created_at);
+	CREATE INDEX ideas_user ON ideas (user_id, created_at);
+	CREATE TABLE feedback (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		idea_id    INTEGER REFERENCES ideas (id) ON DELETE SET NULL,
+		idea_text  TEXT NOT NULL,
+		category   TEXT NOT NULL DEFAULT '',
+		user_id    TEXT NOT NULL DEFAULT '',
+		value      TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX feedback_idea ON feedback (idea_id);
+	CREATE TABLE emails (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL DEFAULT '',
+		type       TEXT NOT NULL DEFAULT '',
+		subject    TEXT NOT NULL DEFAULT '',
+		request    TEXT NOT NULL,
+		html       TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE uploads (
+		filename      TEXT PRIMARY KEY,
+		original_name TEXT NOT NULL DEFAULT '',
+		content_type  TEXT NOT NULL DEFAULT '',
+		size          INTEGER NOT NULL,
+		created_at    INTEGER NOT NULL
+	);
+	CREATE TABLE job_runs (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id     TEXT NOT NULL DEFAULT '',
+		route       TEXT NOT NULL DEFAULT '',
+		specialist  TEXT NOT NULL DEFAULT '',
+		status      TEXT NOT NULL,
+		error       TEXT NOT NULL DEFAULT '',
+		provider    TEXT NOT NULL DEFAULT '',
+		model       TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		started_at  INTEGER NOT NULL
+	);
+	CREATE INDEX job_runs_started ON job_runs (started_at);
+	CREATE TABLE counters (
+		name  TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	);
+	CREATE TABLE meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`,
+
+	// 2: LLM usage and chat sessions
+	`CREATE TABLE llm_usage (
+		day               TEXT NOT NULL,
+		user_id           TEXT NOT NULL,
+		route             TEXT NOT NULL,
+		provider          TEXT NOT NULL,
+		model             TEXT NOT NULL,
+		prompt            TEXT NOT NULL,
+		calls             INTEGER NOT NULL,
+		prompt_tokens     INTEGER NOT NULL,
+		completion_tokens INTEGER NOT NULL,
+		total_tokens      INTEGER NOT NULL,
+		cost_usd          REAL NOT NULL,
+		PRIMARY KEY (day, user_id, route, provider, model, prompt)
+	);
+	CREATE TABLE chat_sessions (
+		id               TEXT PRIMARY KEY,
+		user_id          TEXT NOT NULL,
+		title            TEXT NOT NULL DEFAULT '',
+		system_prompt    TEXT NOT NULL DEFAULT '',
+		summary          TEXT NOT NULL DEFAULT '',
+		summarized_count INTEGER NOT NULL DEFAULT 0,
+		created_at       INTEGER NOT NULL,
+		updated_at       INTEGER NOT NULL
+	);
+	CREATE INDEX chat_sessions_user ON chat_sessions (user_id, updated_at);
+	CREATE TABLE chat_messages (
+		session_id TEXT NOT NULL REFERENCES chat_sessions (id) ON DELETE CASCADE,
+		seq        INTEGER NOT NULL,
+		role       TEXT NOT NULL,
+		content    TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (session_id, seq)
+	);`,
+
+	// 3: one vote per user and idea, feedback lookups by category
+	`DELETE FROM feedback WHERE id NOT IN (
+		SELECT MAX(id) FROM feedback GROUP BY idea_id, user_id, CASE WHEN user_id = '' OR idea_id IS NULL THEN id END
+	);
+	CREATE UNIQUE INDEX feedback_vote ON feedback (idea_id, user_id) WHERE user_id != '' AND idea_id IS NOT NULL;
+	CREATE INDEX feedback_category ON feedback (category);`,
+
+	// 4: daily statistics, backfilled from stored ideas, feedback and premium grants
+	`CREATE TABLE stats_daily (
+		day      TEXT NOT NULL,
+		metric   TEXT NOT NULL,
+		endpoint TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		count    INTEGER NOT NULL,
+		PRIMARY KEY (day, metric, endpoint, category)
+	);
+	CREATE TABLE stats_users (
+		day     TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		PRIMARY KEY (day, user_id)
+	) WITHOUT ROWID;
+	INSERT INTO stats_daily (day, metric, category, count)
+		SELECT date(created_at, 'unixepoch'), 'ideas', category, COUNT(*) FROM ideas GROUP BY 1, 3;
+	INSERT INTO stats_daily (day, metric, category, count)
+		SELECT date(created_at, 'unixepoch'), value, category, COUNT(*) FROM feedback GROUP BY 1, 2, 3;
+	INSERT INTO stats_daily (day, metric, category, count)
+		SELECT date(granted_at, 'unixepoch'), 'premium', source, COUNT(*) FROM premium WHERE source != 'legacy' GROUP BY 1, 3;
+	INSERT OR IGNORE INTO stats_users (day, user_id)
+		SELECT date(created_at, 'unixepoch'), user_id FROM ideas WHERE user_id != '';`,
+
+	// 5: Telegram Stars invoices and their charges
+	`CREATE TABLE payments (
+		payload            TEXT PRIMARY KEY,
+		user_id            TEXT NOT NULL,
+		product            TEXT NOT NULL,
+		currency           TEXT NOT NULL,
+		amount             INTEGER NOT NULL,
+		status             TEXT NOT NULL,
+		charge_id          TEXT UNIQUE,
+		provider_charge_id TEXT NOT NULL DEFAULT '',
+		created_at         INTEGER NOT NULL,
+		paid_at            INTEGER
+	);
+	CREATE INDEX payments_user ON payments (user_id, created_at);`,
+
+	// 6: subscription tiers, per-feature grants and credits with their ledger
+	`ALTER TABLE premium ADD COLUMN tier TEXT NOT NULL DEFAULT 'premium';
+	CREATE TABLE feature_grants (
+		user_id    TEXT NOT NULL,
+		feature    TEXT NOT NULL,
+		source     TEXT NOT NULL,
+		granted_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		PRIMARY KEY (user_id, feature)
+	);
+	CREATE TABLE credits (
+		user_id    TEXT NOT NULL,
+		feature    TEXT NOT NULL,
+		balance    INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, feature)
+	);
+	CREATE TABLE credit_events (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id    TEXT NOT NULL,
+		feature    TEXT NOT NULL,
+		delta      INTEGER NOT NULL,
+		reason     TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX credit_events_user ON credit_events (user_id, created_at);`,
+
+	// 7: x402 payment nonces and receipts
+	`CREATE TABLE x402_nonces (
+		nonce      TEXT PRIMARY KEY,
+		feature    TEXT NOT NULL,
+		resource   TEXT NOT NULL,
+		amount     TEXT NOT NULL,
+		asset      TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		used_at    INTEGER
+	);
+	CREATE TABLE x402_receipts (
+		id          TEXT PRIMARY KEY,
+		nonce       TEXT NOT NULL UNIQUE REFERENCES x402_nonces (nonce),
+		feature     TEXT NOT NULL,
+		resource    TEXT NOT NULL,
+		payer       TEXT NOT NULL,
+		amount      TEXT NOT NULL,
+		asset       TEXT NOT NULL,
+		network     TEXT NOT NULL,
+		tx_hash     TEXT NOT NULL,
+		created_at  INTEGER NOT NULL
+	);`,
+
+	// 8: TON asset orders and the downloads they unlock
+	`CREATE TABLE ton_orders (
+		id          TEXT PRIMARY KEY,
+		user_id     TEXT NOT NULL,
+		asset_id    TEXT NOT NULL,
+		address     TEXT NOT NULL,
+		amount_nano INTEGER NOT NULL,
+		memo        TEXT NOT NULL UNIQUE,
+		status      TEXT NOT NULL,
+		tx_hash     TEXT UNIQUE,
+		sender      TEXT NOT NULL DEFAULT '',
+		created_at  INTEGER NOT NULL,
+		expires_at  INTEGER NOT NULL,
+		paid_at     INTEGER
+	);
+	CREATE INDEX ton_orders_status ON ton_orders (status, created_at);
+	CREATE TABLE asset_grants (
+		user_id    TEXT NOT NULL,
+		asset_id   TEXT NOT NULL,
+		order_id   TEXT NOT NULL,
+		granted_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, asset_id)
+	);`,
+
+	// 9: asset catalog, seeded from assets.json
+	`CREATE TABLE assets (
+		id          TEXT PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		price       REAL NOT NULL,
+		currency    TEXT NOT NULL,
+		category    TEXT NOT NULL DEFAULT '',
+		format      TEXT NOT NULL DEFAULT '',
+		author      TEXT NOT NULL DEFAULT '',
+		status      TEXT NOT NULL,
+		created_at  INTEGER NOT NULL,
+		updated_at  INTEGER NOT NULL
+	);
+	CREATE INDEX assets_category ON assets (category, price);`,
+
+	// 10: expired x402 nonces are purged
+	`CREATE INDEX x402_nonces_expires ON x402_nonces (expires_at);`,
+}
+
+func (s *Store) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return err
+	}
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, i+1, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion is the number of applied migrations.
+func (s *Store) SchemaVersion() (int, error) {
+	var v sql.NullInt64
+	err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v)
+	return int(v.Int64), err
+}
+
+// Meta reads a key from the meta table, "" if unset.
+func (s *Store) Meta(key string) (string, error) {
+	var v string
+	err := s.db.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return v, err
+}
+
+func (s *Store) SetMeta(key, value string) error {
+	_, err := s.db.Exec(`INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+// Incr adds delta to a named counter and returns the new value.
+func (s *Store) Incr(name string, delta int64) (int64, error) {
+	var v int64
+	err := s.db.QueryRow(`INSERT INTO counters (name, value) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = value + excluded.value
+		RETURNING value`, name, delta).Scan(&v)
+	return v, err
+}
+
+// Counter returns a named counter, 0 if it was never incremented.
+func (s *Store) Counter(name string) (int64, error) {
+	var v int64
+	err := s.db.QueryRow(`SELECT value FROM counters WHERE name = ?`, name).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return v, err
+}
+
+func fromUnix(sec int64) time.Time {
+	return time.Unix(sec, 0).UTC()
+}
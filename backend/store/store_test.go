@@ -0,0 +1,204 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTest opens a fresh database in a temporary directory.
+func openTest(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMigrateFromEmpty(t *testing.T) {
+	s := openTest(t)
+	v, err := s.SchemaVersion()
+	if err != nil || v != len(migrations) {
+		t.Fatalf("SchemaVersion = %d, %v, want %d", v, err, len(migrations))
+	}
+	// Reopening applies nothing twice
+	path := filepath.Join(t.TempDir(), "reopen.db")
+	for i := 0; i < 2; i++ {
+		s, err := Open(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		s.Close()
+	}
+}
+
+// TestMigrateUpgrade starts from a database written by the first release
+// and checks that its rows survive and are backfilled by later migrations.
+func TestMigrateUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().Add(-time.Hour).Unix()
+	for _, q := range []string{
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at INTEGER NOT NULL)`,
+		migrations[0],
+		`INSERT INTO schema_migrations (version, applied_at) VALUES (1, 0)`,
+		`INSERT INTO users (id, created_at, last_seen_at) VALUES ('42', 0, 0)`,
+		`INSERT INTO premium (user_id, source, granted_at) VALUES ('42', 'legacy', 0)`,
+	} {
+		if _, err := old.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := old.Exec(`INSERT INTO ideas (category, raw, text, created_at) VALUES ('psx', 'draft', 'Hedgehog racing kit', ?)`,
+		created); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, _ := s.SchemaVersion(); v != len(migrations) {
+		t.Fatalf("SchemaVersion = %d, want %d", v, len(migrations))
+	}
+	p, err := s.GetPremium("42")
+	if err != nil || p.Tier != "premium" || p.ExpiresAt != nil {
+		t.Errorf("premium after upgrade = %+v, %v, want lifetime premium", p, err)
+	}
+	ideas, total, err := s.ListIdeas(IdeaFilter{Category: "psx"})
+	if err != nil || total != 1 || ideas[0].Text != "Hedgehog racing kit" {
+		t.Fatalf("ideas after upgrade = %+v, %d, %v", ideas, total, err)
+	}
+	if found, err := s.SearchIdeas("hedgehog", IdeaFilter{}); err != nil || len(found) != 1 {
+		t.Errorf("search after upgrade (%s) = %+v, %v", s.SearchMode(), found, err)
+	}
+	stats, err := s.ListStats(StatsFilter{Metric: "ideas"})
+	if err != nil || len(stats) != 1 || stats[0].Count != 1 {
+		t.Errorf("backfilled idea stats = %+v, %v", stats, err)
+	}
+}
+
+func TestIdeaRoundTrip(t *testing.T) {
+	s := openTest(t)
+	in := Idea{UserID: "42", Category: "psx", Raw: "draft", Text: "PSX hedgehog pack"}
+	if err := s.AddIdea(&in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := s.GetIdea(in.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.UserID != in.UserID || out.Category != in.Category || out.Raw != in.Raw || out.Text != in.Text ||
+		out.CreatedAt.Unix() != in.CreatedAt.Unix() {
+		t.Errorf("GetIdea = %+v, want %+v", out, in)
+	}
+	if _, err := s.GetIdea(in.ID + 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing idea: %v, want ErrNotFound", err)
+	}
+	if found, err := s.FindIdeaByText("PSX hedgehog pack"); err != nil || found.ID != in.ID {
+		t.Errorf("FindIdeaByText = %+v, %v", found, err)
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	s := openTest(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	in := ChatSession{ID: "chat_1", UserID: "42", SystemPrompt: "Be brief", CreatedAt: now, UpdatedAt: now,
+		Messages: []ChatMessage{{Role: "user", Content: "Hi", CreatedAt: now}}}
+	if err := s.CreateSession(&in); err != nil {
+		t.Fatal(err)
+	}
+	err := s.AppendToSession("chat_1", SessionUpdate{
+		Messages:        []ChatMessage{{Role: "assistant", Content: "Hello", CreatedAt: now}, {Role: "user", Content: "Bye", CreatedAt: now}},
+		Summary:         "greeted",
+		SummarizedCount: 2,
+		Title:           "Greeting",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := s.GetSession("chat_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.UserID != "42" || out.SystemPrompt != "Be brief" || out.Title != "Greeting" ||
+		out.Summary != "greeted" || out.SummarizedCount != 2 || len(out.Messages) != 3 ||
+		out.Messages[2].Content != "Bye" || !out.Messages[0].CreatedAt.Equal(now) {
+		t.Errorf("GetSession = %+v", out)
+	}
+	list, err := s.ListSessions("42")
+	if err != nil || len(list) != 1 || list[0].MessageCount != 3 {
+		t.Errorf("ListSessions = %+v, %v", list, err)
+	}
+
+	if err := s.AppendToSession("chat_2", SessionUpdate{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("append to a missing session: %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteSession("chat_1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession("chat_1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted session: %v, want ErrNotFound", err)
+	}
+}
+
+func TestUsageRoundTrip(t *testing.T) {
+	s := openTest(t)
+	r := UsageRecord{Day: "2026-01-02", UserID: "42", Route: "/api/ai", Provider: "mock", Model: "mock-1",
+		Calls: 1, PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, CostUSD: 0.5}
+	for i := 0; i < 2; i++ {
+		if err := s.AddUsage(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := r
+	other.UserID = "7"
+	if err := s.AddUsage(other); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.ListUsage(UsageFilter{UserID: "42", From: "2026-01-01", To: "2026-01-02"})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("ListUsage = %+v, %v", got, err)
+	}
+	want := r
+	want.Calls, want.PromptTokens, want.CompletionTokens, want.TotalTokens, want.CostUSD = 2, 20, 10, 30, 1
+	if got[0] != want {
+		t.Errorf("usage = %+v, want %+v", got[0], want)
+	}
+	if got, _ := s.ListUsage(UsageFilter{From: "2026-01-03"}); len(got) != 0 {
+		t.Errorf("usage after To: %+v", got)
+	}
+}
+
+func TestJobRunRoundTrip(t *testing.T) {
+	s := openTest(t)
+	started := time.Now().UTC().Truncate(time.Second)
+	in := JobRun{UserID: "42", Route: "/api/supervisor/startup", Specialist: "Namer", Status: "error",
+		Error: "timeout", Provider: "mock", Model: "mock-1", DurationMS: 1200, StartedAt: started}
+	if err := s.AddJobRun(&in); err != nil {
+		t.Fatal(err)
+	}
+	out := JobRun{ID: in.ID}
+	var at int64
+	err := s.db.QueryRow(`SELECT user_id, route, specialist, status, error, provider, model, duration_ms, started_at
+		FROM job_runs WHERE id = ?`, in.ID).
+		Scan(&out.UserID, &out.Route, &out.Specialist, &out.Status, &out.Error, &out.Provider, &out.Model, &out.DurationMS, &at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.StartedAt = fromUnix(at)
+	if out != in {
+		t.Errorf("job run = %+v, want %+v", out, in)
+	}
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const OrderExpired = "expired"
+
+// TonOrder is a purchase paid by a TON transfer of at least AmountNano to
+// Address carrying Memo as its comment. UserID is "" for anonymous buyers,
+// who download with the order id.
+type TonOrder struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id,omitempty"`
+	AssetID    string     `json:"asset_id"`
+	Address    string     `json:"address"`
+	AmountNano int64      `json:"amount_nano"`
+	Memo       string     `json:"memo"`
+	Status     string     `json:"status"` // pending, paid or expired
+	TxHash     string     `json:"tx_hash,omitempty"`
+	Sender     string     `json:"sender,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	PaidAt     *time.Time `json:"paid_at,omitempty"`
+}
+
+// CreateTonOrder stores a new pending order.
+func (s *Store) CreateTonOrder(o *TonOrder) error {
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = time.Now().UTC()
+	}
+	o.Status = PaymentPending
+	_, err := s.db.Exec(`INSERT INTO ton_orders (id, user_id, asset_id, address, amount_nano, memo, status, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		o.ID, o.UserID, o.AssetID, o.Address, o.AmountNano, o.Memo, o.Status, o.CreatedAt.Unix(), o.ExpiresAt.Unix())
+	return err
+}
+
+const tonOrderColumns = `id, user_id, asset_id, address, amount_nano, memo, status, tx_hash, sender, created_at, expires_at, paid_at`
+
+func scanTonOrder(row interface{ Scan(...interface{}) error }) (TonOrder, error) {
+	var o TonOrder
+	var created, expires int64
+	var tx sql.NullString
+	var paid sql.NullInt64
+	err := row.Scan(&o.ID, &o.UserID, &o.AssetID, &o.Address, &o.AmountNano, &o.Memo, &o.Status, &tx, &o.Sender,
+		&created, &expires, &paid)
+	if err != nil {
+		return o, err
+	}
+	o.TxHash = tx.String
+	o.CreatedAt, o.ExpiresAt = fromUnix(created), fromUnix(expires)
+	if paid.Valid {
+		t := fromUnix(paid.Int64)
+		o.PaidAt = &t
+	}
+	return o, nil
+}
+
+func (s *Store) GetTonOrder(id string) (TonOrder, error) {
+	o, err := scanTonOrder(s.db.QueryRow(`SELECT `+tonOrderColumns+` FROM ton_orders WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return o, ErrNotFound
+	}
+	return o, err
+}
+
+// PendingTonOrders returns the orders still waiting for a transfer, oldest
+// first.
+func (s *Store) PendingTonOrders() ([]TonOrder, error) {
+	rows, err := s.db.Query(`SELECT `+tonOrderColumns+` FROM ton_orders WHERE status = ? ORDER BY created_at`,
+		PaymentPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TonOrder
+	for rows.Next() {
+		o, err := scanTonOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// MarkTonOrderPaid records the transfer that paid a pending order. It
+// reports false when the order is no longer pending or the transaction
+// already paid another order.
+func (s *Store) MarkTonOrderPaid(id, txHash, sender string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE OR IGNORE ton_orders SET status = ?, tx_hash = ?, sender = ?, paid_at = ?
+		WHERE id = ? AND status = ?`,
+		PaymentPaid, txHash, sender, time.Now().Unix(), id, PaymentPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ExpireTonOrders gives up on pending orders that expired before the given
+// time and returns how many there were.
+func (s *Store) ExpireTonOrders(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`UPDATE ton_orders SET status = ? WHERE status = ? AND expires_at < ?`,
+		OrderExpired, PaymentPending, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GrantAsset lets a user download an asset.
+func (s *Store) GrantAsset(userID, assetID, orderID string) error {
+	if err := s.TouchUser(userID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO asset_grants (user_id, asset_id, order_id, granted_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, asset_id) DO NOTHING`,
+		userID, assetID, orderID, time.Now().Unix())
+	return err
+}
+
+func (s *Store) HasAsset(userID, assetID string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM asset_grants WHERE user_id = ? AND asset_id = ?`, userID, assetID).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// querier is what *sql.DB and *sql.Tx have in common, so a query can run
+// on its own or as part of a transaction.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tx exposes the store operations that can join a transaction started by
+// the store, such as fulfilling a payment in ClaimPayment. With a single
+// connection the store itself must not be used until the transaction ends.
+type Tx struct {
+	tx *sql.Tx
+}
+
+func (t *Tx) GetPremium(userID string) (Premium, error) {
+	return getPremium(t.tx, userID)
+}
+
+func (t *Tx) GrantTier(userID, tier, source string, expiresAt *time.Time) error {
+	return grantTier(t.tx, userID, tier, source, expiresAt)
+}
+
+func (t *Tx) AddCredits(userID, feature string, n int64, reason string) (int64, error) {
+	return addCredits(t.tx, userID, feature, n, reason)
+}
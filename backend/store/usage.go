@@ -0,0 +1,86 @@
+package store
+
+import "strings"
+
+// UsageRecord aggregates token usage for one day, user, route, model and
+// prompt template.
+type UsageRecord struct {
+	Day              string  `json:"day"`
+	UserID           string  `json:"user_id"`
+	Route            string  `json:"route"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Prompt           string  `json:"prompt,omitempty"`
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// AddUsage adds r's counts to the matching aggregate row.
+func (s *Store) AddUsage(r UsageRecord) error {
+	_, err := s.db.Exec(`INSERT INTO llm_usage (day, user_id, route, provider, model, prompt,
+			calls, prompt_tokens, completion_tokens, total_tokens, cost_usd)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (day, user_id, route, provider, model, prompt) DO UPDATE SET
+			calls = calls + excluded.calls,
+			prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+			completion_tokens = completion_tokens + excluded.completion_tokens,
+			total_tokens = total_tokens + excluded.total_tokens,
+			cost_usd = cost_usd + excluded.cost_usd`,
+		r.Day, r.UserID, r.Route, r.Provider, r.Model, r.Prompt,
+		r.Calls, r.PromptTokens, r.CompletionTokens, r.TotalTokens, r.CostUSD)
+	return err
+}
+
+// UsageFilter narrows ListUsage; empty fields match everything. From and To
+// are inclusive YYYY-MM-DD days.
+type UsageFilter struct {
+	UserID string
+	Route  string
+	From   string
+	To     string
+}
+
+func (s *Store) ListUsage(f UsageFilter) ([]UsageRecord, error) {
+	var where []string
+	var args []interface{}
+	if f.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Route != "" {
+		where = append(where, "route = ?")
+		args = append(args, f.Route)
+	}
+	if f.From != "" {
+		where = append(where, "day >= ?")
+		args = append(args, f.From)
+	}
+	if f.To != "" {
+		where = append(where, "day <= ?")
+		args = append(args, f.To)
+	}
+	query := `SELECT day, user_id, route, provider, model, prompt,
+		calls, prompt_tokens, completion_tokens, total_tokens, cost_usd FROM llm_usage`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UsageRecord
+	for rows.Next() {
+		var r UsageRecord
+		if err := rows.Scan(&r.Day, &r.UserID, &r.Route, &r.Provider, &r.Model, &r.Prompt,
+			&r.Calls, &r.PromptTokens, &r.CompletionTokens, &r.TotalTokens, &r.CostUSD); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TouchUser records that a user was seen, creating the row on first sight.
+func (s *Store) TouchUser(id string) error {
+	return touchUser(s.db, id)
+}
+
+func touchUser(q querier, id string) error {
+	now := time.Now().Unix()
+	_, err := q.Exec(`INSERT INTO users (id, created_at, last_seen_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_seen_at = excluded.last_seen_at`, id, now, now)
+	return err
+}
+
+// Premium is a user's subscription tier. A nil ExpiresAt never expires.
+type Premium struct {
+	UserID    string     `json:"user_id"`
+	Tier      string     `json:"tier"`
+	Source    string     `json:"source"`
+	GrantedAt time.Time  `json:"granted_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// GrantTier sets the user's subscription tier, replacing the previous one.
+func (s *Store) GrantTier(userID, tier, source string, expiresAt *time.Time) error {
+	return grantTier(s.db, userID, tier, source, expiresAt)
+}
+
+func grantTier(q querier, userID, tier, source string, expiresAt *time.Time) error {
+	var expires sql.NullInt64
+	if expiresAt != nil {
+		expires = sql.NullInt64{Int64: expiresAt.Unix(), Valid: true}
+	}
+	if err := touchUser(q, userID); err != nil {
+		return err
+	}
+	_, err := q.Exec(`INSERT INTO premium (user_id, tier, source, granted_at, expires_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET tier = excluded.tier, source = excluded.source,
+			granted_at = excluded.granted_at, expires_at = excluded.expires_at`,
+		userID, tier, source, time.Now().Unix(), expires)
+	return err
+}
+
+// GetPremium returns the user's tier, ErrNotFound if there is none.
+func (s *Store) GetPremium(userID string) (Premium, error) {
+	return getPremium(s.db, userID)
+}
+
+func getPremium(q querier, userID string) (Premium, error) {
+	p := Premium{UserID: userID}
+	var granted int64
+	var expires sql.NullInt64
+	err := q.QueryRow(`SELECT tier, source, granted_at, expires_at FROM premium WHERE user_id = ?`, userID).
+		Scan(&p.Tier, &p.Source, &granted, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	if err != nil {
+		return p, err
+	}
+	p.GrantedAt = fromUnix(granted)
+	if expires.Valid {
+		t := fromUnix(expires.Int64)
+		p.ExpiresAt = &t
+	}
+	return p, nil
+}
+
+// Active reports whether the tier has not expired.
+func (p Premium) Active(now time.Time) bool {
+	return p.ExpiresAt == nil || now.Before(*p.ExpiresAt)
+}
+
+// IsPremium reports whether the user has an unexpired premium tier.
+func (s *Store) IsPremium(userID string) (bool, error) {
+	p, err := s.GetPremium(userID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return p.Tier == "premium" && p.Active(time.Now()), nil
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// X402Nonce is issued with a 402 response and may pay for one call.
+type X402Nonce struct {
+	Nonce     string
+	Feature   string
+	Resource  string
+	Amount    string // atomic units of Asset
+	Asset     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// CreateX402Nonce stores a new nonce and purges the expired ones no
+// receipt refers to.
+func (s *Store) CreateX402Nonce(n *X402Nonce) error {
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now().UTC()
+	}
+	if _, err := s.db.Exec(`DELETE FROM x402_nonces WHERE expires_at < ?
+		AND nonce NOT IN (SELECT nonce FROM x402_receipts)`, n.CreatedAt.Unix()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO x402_nonces (nonce, feature, resource, amount, asset, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		n.Nonce, n.Feature, n.Resource, n.Amount, n.Asset, n.CreatedAt.Unix(), n.ExpiresAt.Unix())
+	return err
+}
+
+func (s *Store) GetX402Nonce(nonce string) (X402Nonce, error) {
+	n := X402Nonce{Nonce: nonce}
+	var created, expires int64
+	var used sql.NullInt64
+	err := s.db.QueryRow(`SELECT feature, resource, amount, asset, created_at, expires_at, used_at
+		FROM x402_nonces WHERE nonce = ?`, nonce).
+		Scan(&n.Feature, &n.Resource, &n.Amount, &n.Asset, &created, &expires, &used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return n, ErrNotFound
+	}
+	n.CreatedAt, n.ExpiresAt, n.Used = fromUnix(created), fromUnix(expires), used.Valid
+	return n, err
+}
+
+// UseX402Nonce marks an unexpired nonce as spent. It reports false when it
+// was already spent or has expired, so one payment never pays twice.
+func (s *Store) UseX402Nonce(nonce string) (bool, error) {
+	now := time.Now().Unix()
+	res, err := s.db.Exec(`UPDATE x402_nonces SET used_at = ? WHERE nonce = ? AND used_at IS NULL AND expires_at > ?`,
+		now, nonce, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseX402Nonce makes a spent nonce usable again, for a call that
+// failed before its payment was settled.
+func (s *Store) ReleaseX402Nonce(nonce string) error {
+	_, err := s.db.Exec(`UPDATE x402_nonces SET used_at = NULL WHERE nonce = ?`, nonce)
+	return err
+}
+
+// X402Receipt records a settled x402 payment.
+type X402Receipt struct {
+	ID          string    `json:"id"`
+	Nonce       string    `json:"nonce"`
+	Feature     string    `json:"feature"`
+	Resource    string    `json:"resource"`
+	Payer       string    `json:"payer"`
+	Amount      string    `json:"amount"`
+	Asset       string    `json:"asset"`
+	Network     string    `json:"network"`
+	Transaction string    `json:"transaction"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (s *Store) SaveX402Receipt(r *X402Receipt) error {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.db.Exec(`INSERT INTO x402_receipts (id, nonce, feature, resource, payer, amount, asset, network, tx_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.Nonce, r.Feature, r.Resource, r.Payer, r.Amount, r.Asset, r.Network, r.Transaction, r.CreatedAt.Unix())
+	return err
+}
+
+func (s *Store) GetX402Receipt(id string) (X402Receipt, error) {
+	r := X402Receipt{ID: id}
+	var created int64
+	err := s.db.QueryRow(`SELECT nonce, feature, resource, payer, amount, asset, network, tx_hash, created_at
+		FROM x402_receipts WHERE id = ?`, id).
+		Scan(&r.Nonce, &r.Feature, &r.Resource, &r.Payer, &r.Amount, &r.Asset, &r.Network, &r.Transaction, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrNotFound
+	}
+	r.CreatedAt = fromUnix(created)
+	return r, err
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateX402NoncePurgesExpired(t *testing.T) {
+	s := openTest(t)
+	past := time.Now().Add(-time.Hour)
+	for _, n := range []X402Nonce{
+		{Nonce: "expired", ExpiresAt: past},
+		{Nonce: "paid", ExpiresAt: past},
+		{Nonce: "live", ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		n.CreatedAt = past.Add(-time.Minute)
+		if err := s.CreateX402Nonce(&n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveX402Receipt(&X402Receipt{ID: "rcpt", Nonce: "paid"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateX402Nonce(&X402Nonce{Nonce: "new", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetX402Nonce("expired"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired nonce: %v, want purged", err)
+	}
+	for _, nonce := range []string{"paid", "live", "new"} {
+		if _, err := s.GetX402Nonce(nonce); err != nil {
+			t.Errorf("%s: %v", nonce, err)
+		}
+	}
+}
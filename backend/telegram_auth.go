@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The Mini App sends Telegram.WebApp.initData in this header (or as
+// "Authorization: tma <initData>"). It is signed with a key derived from the
+// bot token, see https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+const initDataHeader = "X-Telegram-Init-Data"
+
+const telegramUserKey = "telegram_user"
+
+// telegramUser is the "user" field of initData.
+type telegramUser struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
+	IsPremium    bool   `json:"is_premium,omitempty"`
+}
+
+// UserID is the id premium and usage are keyed by.
+func (u telegramUser) UserID() string {
+	return strconv.FormatInt(u.ID, 10)
+}
+
+var (
+	telegramBotToken string
+	// telegramAuthDev trusts the user_id in the request when there is no
+	// bot token (TELEGRAM_AUTH_DEV=1, local development only). Without
+	// either, endpoints that need a user answer 503.
+	telegramAuthDev bool
+	// initDataMaxAge rejects initData signed longer ago than this.
+	initDataMaxAge = 24 * time.Hour
+)
+
+func setupTelegramAuth() {
+	telegramBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+	maxAge, err := time.ParseDuration(envOr("TELEGRAM_AUTH_MAX_AGE", "24h"))
+	if err != nil || maxAge <= 0 {
+		log.Printf("TELEGRAM_AUTH_MAX_AGE: invalid value, using 24h")
+		maxAge = 24 * time.Hour
+	}
+	initDataMaxAge = maxAge
+	telegramAuthDev = os.Getenv("TELEGRAM_AUTH_DEV") == "1"
+	switch {
+	case telegramBotToken == "" && telegramAuthDev:
+		log.Printf("TELEGRAM_AUTH_DEV=1: endpoints trust the user_id in the request (development only)")
+	case telegramBotToken == "":
+		log.Printf("TELEGRAM_BOT_TOKEN is not set: endpoints that need a user are disabled")
+	case telegramAuthDev:
+		log.Printf("TELEGRAM_AUTH_DEV is ignored when TELEGRAM_BOT_TOKEN is set")
+	}
+}
+
+var (
+	errInitDataHash    = errors.New("hash mismatch")
+	errInitDataExpired = errors.New("auth_date expired")
+)
+
+// validateInitData checks the initData signature and auth_date and returns
+// the Telegram user it was issued for.
+func validateInitData(raw, botToken string, maxAge time.Duration, now time.Time) (telegramUser, error) {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return telegramUser{}, err
+	}
+	hash := values.Get("hash")
+	if hash == "" {
+		return telegramUser{}, errors.New("missing hash")
+	}
+
+	// data-check-string: every field but hash as key=value, sorted, joined by \n
+	pairs := make([]string, 0, len(values))
+	for k, v := range values {
+		if k != "hash" && len(v) > 0 {
+			pairs = append(pairs, k+"="+v[0])
+		}
+	}
+	sort.Strings(pairs)
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(strings.ToLower(hash)), []byte(want)) {
+		return telegramUser{}, errInitDataHash
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return telegramUser{}, errors.New("invalid auth_date")
+	}
+	if age := now.Sub(time.Unix(authDate, 0)); maxAge > 0 && age > maxAge {
+		return telegramUser{}, errInitDataExpired
+	}
+
+	var user telegramUser
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil {
+		return telegramUser{}, fmt.Errorf("invalid user: %w", err)
+	}
+	if user.ID == 0 {
+		return telegramUser{}, errors.New("missing user id")
+	}
+	return user, nil
+}
+
+func initDataFromRequest(c *gin.Context) string {
+	if raw := c.GetHeader(initDataHeader); raw != "" {
+		return raw
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "tma ") {
+		return strings.TrimPrefix(auth, "tma ")
+	}
+	return ""
+}
+
+// requireTelegramUser authenticates the caller by initData. Without
+// TELEGRAM_BOT_TOKEN it answers 503, unless TELEGRAM_AUTH_DEV lets handlers
+// fall back to the body user_id.
+func requireTelegramUser() gin.HandlerFunc {
+	return telegramAuth(true)
+}
+
+// optionalTelegramUser checks initData only when it is sent, for routes that
+// also admit callers without a Telegram identity (x402-paying agents).
+func optionalTelegramUser() gin.HandlerFunc {
+	return telegramAuth(false)
+}
+
+func telegramAuth(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if telegramBotToken == "" {
+			if required && !telegramAuthDev {
+				abortNoCaller(c)
+				return
+			}
+			c.Next()
+			return
+		}
+		raw := initDataFromRequest(c)
+		if raw == "" {
+			if required {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Telegram authentication required"})
+				return
+			}
+			c.Next()
+			return
+		}
+		user, err := validateInitData(raw, telegramBotToken, initDataMaxAge, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Telegram init data: " + err.Error()})
+			return
+		}
+		c.Set(telegramUserKey, user)
+		c.Next()
+	}
+}
+
+// requestUser returns the authenticated Telegram user id, or bodyID in
+// TELEGRAM_AUTH_DEV mode. It answers itself when there is none.
+func requestUser(c *gin.Context, bodyID string) (string, bool) {
+	if v, ok := c.Get(telegramUserKey); ok {
+		return v.(telegramUser).UserID(), true
+	}
+	if trustRequestUser() && bodyID != "" {
+		return bodyID, true
+	}
+	abortNoCaller(c)
+	return "", false
+}
+
+// trustRequestUser reports whether the user_id a client sends is taken as is.
+func trustRequestUser() bool {
+	return telegramBotToken == "" && telegramAuthDev
+}
+
+// abortNoCaller answers a request that needs a user and has none: 401
+// without initData, 503 when Telegram auth is not configured and 400 in
+// development mode without a user_id.
+func abortNoCaller(c *gin.Context) {
+	switch {
+	case telegramBotToken != "":
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Telegram authentication required"})
+	case !telegramAuthDev:
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Telegram authentication is not configured"})
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// botAPI is a minimal Telegram Bot API client. TELEGRAM_API_URL points it
+// at another server, e.g. the fake one in cmd/fakebotapi.
+type botAPI struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// bot is nil when TELEGRAM_BOT_TOKEN is not set.
+var bot *botAPI
+
+func newBotAPI(base, token string) *botAPI {
+	return &botAPI{base: strings.TrimRight(base, "/"), token: token, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+type botResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+}
+
+// call invokes a Bot API method with JSON params and decodes the result
+// into out (which may be nil).
+func (b *botAPI) call(ctx context.Context, method string, params, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.base+"/bot"+b.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var r botResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("telegram %s: %s", method, resp.Status)
+	}
+	if !r.OK {
+		return fmt.Errorf("telegram %s: %d %s", method, r.ErrorCode, r.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(r.Result, out)
+	}
+	return nil
+}
+
+type labeledPrice struct {
+	Label  string `json:"label"`
+	Amount int64  `json:"amount"`
+}
+
+// invoiceParams are the createInvoiceLink fields Stars invoices use; the
+// provider token stays empty for XTR.
+type invoiceParams struct {
+	Title         string         `json:"title"`
+	Description   string         `json:"description"`
+	Payload       string         `json:"payload"`
+	ProviderToken string         `json:"provider_token"`
+	Currency      string         `json:"currency"`
+	Prices        []labeledPrice `json:"prices"`
+}
+
+func (b *botAPI) CreateInvoiceLink(ctx context.Context, p invoiceParams) (string, error) {
+	var link string
+	err := b.call(ctx, "createInvoiceLink", p, &link)
+	return link, err
+}
+
+// AnswerPreCheckoutQuery confirms or declines a checkout; Telegram waits
+// at most 10 seconds for it.
+func (b *botAPI) AnswerPreCheckoutQuery(ctx context.Context, id string, ok bool, errorMessage string) error {
+	return b.call(ctx, "answerPreCheckoutQuery", map[string]interface{}{
+		"pre_checkout_query_id": id,
+		"ok":                    ok,
+		"error_message":         errorMessage,
+	}, nil)
+}
+
+// Webhook update types, only the fields payments need.
+
+type botUser struct {
+	ID int64 `json:"id"`
+}
+
+type preCheckoutQuery struct {
+	ID             string  `json:"id"`
+	From           botUser `json:"from"`
+	Currency       string  `json:"currency"`
+	TotalAmount    int64   `json:"total_amount"`
+	InvoicePayload string  `json:"invoice_payload"`
+}
+
+type successfulPayment struct {
+	Currency                string `json:"currency"`
+	TotalAmount             int64  `json:"total_amount"`
+	InvoicePayload          string `json:"invoice_payload"`
+	TelegramPaymentChargeID string `json:"telegram_payment_charge_id"`
+	ProviderPaymentChargeID string `json:"provider_payment_charge_id"`
+}
+
+type botMessage struct {
+	MessageID         int64              `json:"message_id"`
+	From              botUser            `json:"from"`
+	SuccessfulPayment *successfulPayment `json:"successful_payment,omitempty"`
+}
+
+type botUpdate struct {
+	UpdateID         int64             `json:"update_id"`
+	Message          *botMessage       `json:"message,omitempty"`
+	PreCheckoutQuery *preCheckoutQuery `json:"pre_checkout_query,omitempty"`
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// TON purchases: POST /api/ton/orders reserves a catalog asset at its price
+// and returns a unique memo. The buyer sends the amount to the shop address
+// with the memo as the transfer comment; a poller asks the TON indexer for
+// incoming transfers, marks the matching order paid and grants the download.
+
+// defaultTonAddress is the wallet published in AGENT_CARD.json.
+const defaultTonAddress = "UQDqNihspM0odGiyRM2UkmsTa-GjuYY5Vfr1eOn93WRGx6ZL"
+
+const nanotons = 1_000_000_000
+
+// tonTransfer is an incoming transfer as reported by the indexer.
+type tonTransfer struct {
+	Hash       string
+	Sender     string
+	AmountNano int64
+	Comment    string
+	Time       time.Time
+}
+
+// tonIndexer lists the incoming transfers to an address since a time. The
+// toncenter client is the real one; cmd/faketonindexer serves the same API
+// for local tests.
+type tonIndexer interface {
+	Incoming(ctx context.Context, address string, since time.Time) ([]tonTransfer, error)
+}
+
+type tonConfig struct {
+	Address  string
+	TTL      time.Duration
+	Interval time.Duration
+	Dir      string // asset files, <id>.<ext>
+	indexer  tonIndexer
+}
+
+var ton *tonConfig
+
+func setupTON() {
+	cfg := &tonConfig{
+		Address: envOr("TON_PAY_TO", defaultTonAddress),
+		Dir:     envOr("ASSETS_DIR", "assets"),
+		indexer: &toncenterIndexer{
+			url:    strings.TrimRight(envOr("TON_INDEXER_URL", "https://toncenter.com"), "/"),
+			apiKey: os.Getenv("TON_INDEXER_API_KEY"),
+			client: &http.Client{Timeout: 20 * time.Second},
+		},
+	}
+	var err error
+	if cfg.TTL, err = time.ParseDuration(envOr("TON_ORDER_TTL", "30m")); err != nil || cfg.TTL <= 0 {
+		log.Printf("TON_ORDER_TTL: invalid value, using 30m")
+		cfg.TTL = 30 * time.Minute
+	}
+	if cfg.Interval, err = time.ParseDuration(envOr("TON_POLL_INTERVAL", "15s")); err != nil || cfg.Interval < 0 {
+		log.Printf("TON_POLL_INTERVAL: invalid value, using 15s")
+		cfg.Interval = 15 * time.Second
+	}
+	ton = cfg
+	if cfg.Interval > 0 {
+		go cfg.run(context.Background())
+	}
+}
+
+func (cfg *tonConfig) run(ctx context.Context) {
+	t := time.NewTicker(cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := cfg.poll(ctx); err != nil {
+				log.Printf("TON poll: %v", err)
+			}
+		}
+	}
+}
+
+// poll matches pending orders against the transfers that arrived since the
+// oldest of them. Orders are expired one interval late, so a transfer the
+// indexer reports with a delay still counts.
+func (cfg *tonConfig) poll(ctx context.Context) error {
+	if _, err := db.ExpireTonOrders(time.Now().Add(-cfg.Interval)); err != nil {
+		return err
+	}
+	orders, err := db.PendingTonOrders()
+	if err != nil || len(orders) == 0 {
+		return err
+	}
+	byAddress := map[string][]store.TonOrder{}
+	for _, o := range orders {
+		byAddress[o.Address] = append(byAddress[o.Address], o)
+	}
+	for address, pending := range byAddress {
+		transfers, err := cfg.indexer.Incoming(ctx, address, pending[0].CreatedAt)
+		if err != nil {
+			return err
+		}
+		for _, o := range pending {
+			for _, tr := range transfers {
+				if tr.matches(o) {
+					settleTonOrder(o, tr)
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (tr tonTransfer) matches(o store.TonOrder) bool {
+	return strings.TrimSpace(tr.Comment) == o.Memo && tr.AmountNano >= o.AmountNano && !tr.Time.Before(o.CreatedAt)
+}
+
+// settleTonOrder marks the order paid and grants the asset to its buyer.
+// Anonymous buyers download with the order id instead.
+func settleTonOrder(o store.TonOrder, tr tonTransfer) {
+	paid, err := db.MarkTonOrderPaid(o.ID, tr.Hash, tr.Sender)
+	if err != nil {
+		log.Printf("TON order %s: %v", o.ID, err)
+		return
+	}
+	if !paid {
+		log.Printf("TON order %s: transaction %s already paid another order", o.ID, tr.Hash)
+		return
+	}
+	log.Printf("TON order %s paid: %d nanoton from %s, tx %s", o.ID, tr.AmountNano, tr.Sender, tr.Hash)
+	if o.UserID != "" {
+		if err := db.GrantAsset(o.UserID, o.AssetID, o.ID); err != nil {
+			log.Printf("TON order %s grant: %v", o.ID, err)
+		}
+	}
+	recordStat("ton", "", o.AssetID)
+}
+
+// toncenterIndexer reads transactions with toncenter's v2 getTransactions,
+// paging back from the newest one with lt and hash until it reaches the
+// requested time.
+type toncenterIndexer struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+const (
+	toncenterPageSize = 100
+	toncenterMaxPages = 50
+)
+
+// toncenterTx is the part of a raw.transaction the shop reads.
+type toncenterTx struct {
+	Utime         int64 `json:"utime"`
+	TransactionID struct {
+		LT   string `json:"lt"`
+		Hash string `json:"hash"`
+	} `json:"transaction_id"`
+	InMsg *struct {
+		Source  string `json:"source"`
+		Value   string `json:"value"`
+		Message string `json:"message"`
+	} `json:"in_msg"`
+}
+
+func (t *toncenterIndexer) Incoming(ctx context.Context, address string, since time.Time) ([]tonTransfer, error) {
+	var out []tonTransfer
+	var lt, hash string
+	for page := 0; page < toncenterMaxPages; page++ {
+		txs, err := t.transactions(ctx, address, lt, hash)
+		if err != nil {
+			return nil, err
+		}
+		full := len(txs) == toncenterPageSize
+		// A page starts with the transaction it was asked from, the last
+		// one of the previous page
+		if lt != "" && len(txs) > 0 && txs[0].TransactionID.Hash == hash {
+			txs = txs[1:]
+		}
+		for _, tx := range txs {
+			at := time.Unix(tx.Utime, 0)
+			if at.Before(since) {
+				return out, nil
+			}
+			// External messages have no source and carry no value
+			if tx.InMsg == nil || tx.InMsg.Source == "" {
+				continue
+			}
+			amount, err := strconv.ParseInt(tx.InMsg.Value, 10, 64)
+			if err != nil {
+				continue
+			}
+			out = append(out, tonTransfer{Hash: tx.TransactionID.Hash, Sender: tx.InMsg.Source,
+				AmountNano: amount, Comment: tx.InMsg.Message, Time: at})
+		}
+		if !full || len(txs) == 0 {
+			return out, nil
+		}
+		last := txs[len(txs)-1].TransactionID
+		lt, hash = last.LT, last.Hash
+	}
+	log.Printf("TON indexer: stopped after %d pages of %s", toncenterMaxPages, address)
+	return out, nil
+}
+
+// transactions fetches one page, newest first, starting at lt and hash when
+// they are set.
+func (t *toncenterIndexer) transactions(ctx context.Context, address, lt, hash string) ([]toncenterTx, error) {
+	q := url.Values{"address": {address}, "limit": {strconv.Itoa(toncenterPageSize)}, "archival": {"true"}}
+	if lt != "" {
+		q.Set("lt", lt)
+		q.Set("hash", hash)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url+"/api/v2/getTransactions?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if t.apiKey != "" {
+		req.Header.Set("X-API-Key", t.apiKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		OK     bool          `json:"ok"`
+		Error  string        `json:"error"`
+		Result []toncenterTx `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("indexer: %s: %w", resp.Status, err)
+	}
+	if !body.OK {
+		return nil, fmt.Errorf("indexer: %s: %s", resp.Status, body.Error)
+	}
+	return body.Result, nil
+}
+
+func formatTON(nano int64) string {
+	return strconv.FormatFloat(float64(nano)/nanotons, 'f', -1, 64)
+}
+
+// tonOrderJSON adds what the buyer needs to pay and, once paid, download.
+func tonOrderJSON(o store.TonOrder) gin.H {
+	q := url.Values{"amount": {strconv.FormatInt(o.AmountNano, 10)}, "text": {o.Memo}}
+	resp := gin.H{
+		"order_id":    o.ID,
+		"asset_id":    o.AssetID,
+		"status":      o.Status,
+		"address":     o.Address,
+		"amount":      formatTON(o.AmountNano),
+		"amount_nano": o.AmountNano,
+		"currency":    "TON",
+		"memo":        o.Memo,
+		"payment_url": "ton://transfer/" + o.Address + "?" + q.Encode(),
+		"created_at":  o.CreatedAt,
+		"expires_at":  o.ExpiresAt,
+	}
+	if o.Status == store.PaymentPaid {
+		resp["tx_hash"] = o.TxHash
+		resp["paid_at"] = o.PaidAt
+		resp["download_url"] = "/api/b2a/assets/" + url.PathEscape(o.AssetID) + "/download?order=" + url.QueryEscape(o.ID)
+	}
+	return resp
+}
+
+func handleCreateTonOrder(c *gin.Context) {
+	userID := callerID(c)
+	var req struct {
+		AssetID string `json:"asset_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Asset ID is required"})
+		return
+	}
+	asset, ok := findAsset(c, req.AssetID)
+	if !ok {
+		return
+	}
+	if asset.Currency != "TON" || asset.Price <= 0 || asset.Status != "available" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Asset is not for sale in TON"})
+		return
+	}
+	memo, err := randomToken("ezhik-", 6)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := randomToken("ton_", 16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	o := store.TonOrder{
+		ID:         id,
+		UserID:     userID,
+		AssetID:    asset.ID,
+		Address:    ton.Address,
+		AmountNano: int64(math.Round(asset.Price * nanotons)),
+		Memo:       memo,
+		ExpiresAt:  time.Now().Add(ton.TTL).UTC(),
+	}
+	if err := db.CreateTonOrder(&o); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, tonOrderJSON(o))
+}
+
+func handleGetTonOrder(c *gin.Context) {
+	o, err := db.GetTonOrder(c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tonOrderJSON(o))
+}
+
+// handleAssetDownload serves an asset file to a user it was granted to, or
+// to anyone holding the id of a paid order for it (?order=).
+func handleAssetDownload(c *gin.Context) {
+	assetID := c.Param("id")
+	allowed := false
+	if orderID := c.Query("order"); orderID != "" {
+		o, err := db.GetTonOrder(orderID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		allowed = err == nil && o.AssetID == assetID && o.Status == store.PaymentPaid
+	}
+	if userID := callerID(c); !allowed && userID != "" {
+		var err error
+		if allowed, err = db.HasAsset(userID, assetID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error": "Purchase required. Pay in TON to download this asset!",
+			"code":  "payment_required",
+			"asset": assetID,
+			"pay":   "/api/ton/orders",
+		})
+		return
+	}
+
+	files, _ := filepath.Glob(filepath.Join(ton.Dir, filepath.Base(assetID)+".*"))
+	if len(files) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset file is not available yet"})
+		return
+	}
+	c.FileAttachment(files[0], filepath.Base(files[0]))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"ezhik-ideas/store"
+)
+
+// toncenterStub serves n transfers to one address, one a minute up to now,
+// paging with lt and hash like toncenter.
+func toncenterStub(t *testing.T, n int, now time.Time) (*toncenterIndexer, *int) {
+	t.Helper()
+	txs := make([]toncenterTx, n) // newest first
+	for i := range txs {
+		tx := &txs[i]
+		tx.Utime = now.Add(-time.Duration(i) * time.Minute).Unix()
+		tx.TransactionID.LT = strconv.Itoa(10000 - i)
+		tx.TransactionID.Hash = "h" + strconv.Itoa(i)
+		tx.InMsg = &struct {
+			Source  string `json:"source"`
+			Value   string `json:"value"`
+			Message string `json:"message"`
+		}{"EQsender", "1000", "memo" + strconv.Itoa(i)}
+	}
+	requests := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		q := r.URL.Query()
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		start := 0
+		if lt := q.Get("lt"); lt != "" {
+			for start < len(txs) && txs[start].TransactionID.LT != lt {
+				start++
+			}
+		}
+		end := start + limit
+		if end > len(txs) {
+			end = len(txs)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": txs[start:end]})
+	}))
+	t.Cleanup(api.Close)
+	return &toncenterIndexer{url: api.URL, client: api.Client()}, &requests
+}
+
+func TestToncenterPaging(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	idx, requests := toncenterStub(t, 250, now)
+
+	got, err := idx.Incoming(context.Background(), "EQshop", now.Add(-150*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 151 {
+		t.Fatalf("got %d transfers, want 151", len(got))
+	}
+	seen := map[string]bool{}
+	for _, tr := range got {
+		if seen[tr.Hash] {
+			t.Fatalf("transfer %s reported twice", tr.Hash)
+		}
+		seen[tr.Hash] = true
+	}
+	if got[150].Hash != "h150" || *requests != 2 {
+		t.Errorf("last = %s after %d requests, want h150 after 2", got[150].Hash, *requests)
+	}
+
+	// Everything newer than since: pages until the history runs out
+	*requests = 0
+	if got, err = idx.Incoming(context.Background(), "EQshop", now.Add(-24*time.Hour)); err != nil || len(got) != 250 {
+		t.Fatalf("got %d transfers, %v; want all 250", len(got), err)
+	}
+	if *requests != 3 {
+		t.Errorf("%d requests, want 3", *requests)
+	}
+}
+
+// fakeIndexer reports the same transfers for every address.
+type fakeIndexer struct {
+	transfers []tonTransfer
+	since     time.Time
+}
+
+func (f *fakeIndexer) Incoming(ctx context.Context, address string, since time.Time) ([]tonTransfer, error) {
+	f.since = since
+	return f.transfers, nil
+}
+
+func TestTonPoll(t *testing.T) {
+	useTestDB(t)
+	idx := &fakeIndexer{}
+	prev := ton
+	ton = &tonConfig{Address: "EQshop", TTL: time.Hour, Interval: time.Minute, indexer: idx}
+	t.Cleanup(func() { ton = prev })
+
+	now := time.Now().UTC().Truncate(time.Second)
+	order := func(id, user string, expires time.Time) store.TonOrder {
+		o := store.TonOrder{ID: id, UserID: user, AssetID: "asset-" + id, Address: ton.Address,
+			AmountNano: 2 * nanotons, Memo: "memo-" + id, CreatedAt: now.Add(-10 * time.Minute), ExpiresAt: expires}
+		if err := db.CreateTonOrder(&o); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	later := now.Add(time.Hour)
+	paid := order("paid", "7", later)
+	under := order("under", "7", later)
+	first := order("first", "", later)
+	second := order("second", "", later)
+	early := order("early", "", later)
+	expired := order("expired", "", now.Add(-2*time.Minute))
+
+	transfer := func(hash, memo string, nano int64, at time.Time) tonTransfer {
+		return tonTransfer{Hash: hash, Sender: "EQbuyer", AmountNano: nano, Comment: memo, Time: at}
+	}
+	idx.transfers = []tonTransfer{
+		transfer("tx1", " "+paid.Memo+"\n", paid.AmountNano+1, now),
+		transfer("tx2", under.Memo, under.AmountNano-1, now),
+		transfer("tx3", first.Memo, first.AmountNano, now),
+		transfer("tx3", second.Memo, second.AmountNano, now), // the same transaction again
+		transfer("tx4", early.Memo, early.AmountNano, early.CreatedAt.Add(-time.Second)),
+		transfer("tx5", expired.Memo, expired.AmountNano, now),
+	}
+	if err := ton.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !idx.since.Equal(paid.CreatedAt) {
+		t.Errorf("asked for transfers since %v, want %v", idx.since, paid.CreatedAt)
+	}
+
+	for _, tc := range []struct {
+		id, status, tx string
+	}{
+		{"paid", store.PaymentPaid, "tx1"},
+		{"under", store.PaymentPending, ""},
+		{"first", store.PaymentPaid, "tx3"},
+		{"second", store.PaymentPending, ""},
+		{"early", store.PaymentPending, ""},
+		{"expired", store.OrderExpired, ""},
+	} {
+		o, err := db.GetTonOrder(tc.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Status != tc.status || o.TxHash != tc.tx {
+			t.Errorf("order %s: %s %q, want %s %q", tc.id, o.Status, o.TxHash, tc.status, tc.tx)
+		}
+	}
+	if ok, _ := db.HasAsset("7", paid.AssetID); !ok {
+		t.Error("paid asset not granted")
+	}
+	if ok, _ := db.HasAsset("7", under.AssetID); ok {
+		t.Error("underpaid asset granted")
+	}
+
+	// A later transfer of the full amount still pays the underpaid order
+	idx.transfers = []tonTransfer{transfer("tx6", under.Memo, under.AmountNano, now)}
+	if err := ton.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := db.GetTonOrder("under"); o.Status != store.PaymentPaid {
+		t.Errorf("under: %s after a full transfer, want paid", o.Status)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// callScope describes who an LLM call is made for. llmRouting attaches one
+// to every request; handlers fill in the user once they know it.
+type callScope struct {
+	Route       string
+	UserID      string
+	Locale      string
+	CacheBypass bool
+}
+
+type callScopeKey struct{}
+
+func scopeFromContext(ctx context.Context) *callScope {
+	if s, ok := ctx.Value(callScopeKey{}).(*callScope); ok {
+		return s
+	}
+	return &callScope{}
+}
+
+// setCallUser attributes the LLM usage of the current request to userID.
+func setCallUser(c *gin.Context, userID string) {
+	scopeFromContext(c.Request.Context()).UserID = userID
+	if userID != "" {
+		if err := db.TouchUser(userID); err != nil {
+			log.Printf("User update error: %v", err)
+		}
+	}
+}
+
+// modelPrice is USD per million tokens.
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+var modelPrices = map[string]modelPrice{
+	"llama-3.3-70b-versatile":  {0.59, 0.79},
+	"llama-3.1-8b-instant":     {0.05, 0.08},
+	"gpt-4o-mini":              {0.15, 0.60},
+	"gpt-4o":                   {2.50, 10.00},
+	"claude-3-5-sonnet-latest": {3.00, 15.00},
+	"claude-3-5-haiku-latest":  {0.80, 4.00},
+}
+
+func loadPrices() {
+	// LLM_PRICES=llama-3.3-70b-versatile=0.59/0.79,gpt-4o-mini=0.15/0.6
+	for _, entry := range splitList(os.Getenv("LLM_PRICES")) {
+		model, prices, _ := strings.Cut(entry, "=")
+		in, out, _ := strings.Cut(prices, "/")
+		inPrice, err1 := strconv.ParseFloat(in, 64)
+		outPrice, err2 := strconv.ParseFloat(out, 64)
+		if err1 != nil || err2 != nil {
+			log.Printf("LLM_PRICES: ignoring malformed entry %q", entry)
+			continue
+		}
+		modelPrices[strings.TrimSpace(model)] = modelPrice{inPrice, outPrice}
+	}
+}
+
+func usageCost(model string, u Usage) float64 {
+	price, ok := modelPrices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(u.PromptTokens)*price.Input + float64(u.CompletionTokens)*price.Output) / 1e6
+}
+
+// recordUsage attributes a successful completion to the user and route of ctx.
+func recordUsage(ctx context.Context, comp Completion) {
+	scope := scopeFromContext(ctx)
+	err := db.AddUsage(store.UsageRecord{
+		Day:              time.Now().UTC().Format("2006-01-02"),
+		UserID:           scope.UserID,
+		Route:            scope.Route,
+		Provider:         comp.Provider,
+		Model:            comp.Model,
+		Prompt:           comp.Prompt,
+		Calls:            1,
+		PromptTokens:     comp.Usage.PromptTokens,
+		CompletionTokens: comp.Usage.CompletionTokens,
+		TotalTokens:      comp.Usage.TotalTokens,
+		CostUSD:          usageCost(comp.Model, comp.Usage),
+	})
+	if err != nil {
+		log.Printf("Usage record error: %v", err)
+	}
+}
+
+type usageRow struct {
+	Key              string  `json:"key"`
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+func (row *usageRow) add(r store.UsageRecord) {
+	row.Calls += r.Calls
+	row.PromptTokens += r.PromptTokens
+	row.CompletionTokens += r.CompletionTokens
+	row.TotalTokens += r.TotalTokens
+	row.CostUSD += r.CostUSD
+}
+
+// handleUsage reports token usage grouped by user, day, feature (route),
+// provider, model or prompt template variant. Optional filters: user_id, feature, from, to (YYYY-MM-DD).
+func handleUsage(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "feature")
+	var keyOf func(r store.UsageRecord) string
+	switch groupBy {
+	case "user":
+		keyOf = func(r store.UsageRecord) string { return r.UserID }
+	case "day":
+		keyOf = func(r store.UsageRecord) string { return r.Day }
+	case "feature":
+		keyOf = func(r store.UsageRecord) string { return r.Route }
+	case "provider":
+		keyOf = func(r store.UsageRecord) string { return r.Provider }
+	case "model":
+		keyOf = func(r store.UsageRecord) string { return r.Model }
+	case "prompt":
+		keyOf = func(r store.UsageRecord) string { return r.Prompt }
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be one of user, day, feature, provider, model, prompt"})
+		return
+	}
+
+	records, err := db.ListUsage(store.UsageFilter{
+		UserID: c.Query("user_id"),
+		Route:  c.Query("feature"),
+		From:   c.Query("from"),
+		To:     c.Query("to"),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows := make(map[string]*usageRow)
+	total := &usageRow{Key: "total"}
+	for _, r := range records {
+		key := keyOf(r)
+		row, ok := rows[key]
+		if !ok {
+			row = &usageRow{Key: key}
+			rows[key] = row
+		}
+		row.add(r)
+		total.add(r)
+	}
+
+	out := make([]*usageRow, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+
+	c.JSON(http.StatusOK, gin.H{"group_by": groupBy, "rows": out, "total": total})
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ezhik-ideas/store"
+	"github.com/gin-gonic/gin"
+)
+
+// x402 lets agents pay per call over HTTP (https://www.x402.org). An unpaid
+// call to a priced feature gets 402 with "accepts" requirements; the client
+// pays and retries with an X-PAYMENT header, which a facilitator verifies and
+// settles. The answer carries an X-PAYMENT-RESPONSE header with the receipt.
+//
+// Every requirement carries a one-time nonce in extra.nonce that the payment
+// payload must echo as "nonce", so a payment cannot be replayed.
+
+const (
+	x402Version        = 1
+	x402Header         = "X-PAYMENT"
+	x402ResponseHeader = "X-PAYMENT-RESPONSE"
+)
+
+// x402Requirement is one entry of "accepts" in a 402 response.
+type x402Requirement struct {
+	Scheme            string            `json:"scheme"`
+	Network           string            `json:"network"`
+	MaxAmountRequired string            `json:"maxAmountRequired"`
+	Resource          string            `json:"resource"`
+	Description       string            `json:"description"`
+	MimeType          string            `json:"mimeType"`
+	PayTo             string            `json:"payTo"`
+	MaxTimeoutSeconds int               `json:"maxTimeoutSeconds"`
+	Asset             string            `json:"asset"`
+	Extra             map[string]string `json:"extra,omitempty"`
+}
+
+// x402Payment is the decoded X-PAYMENT header; Payload is scheme specific.
+type x402Payment struct {
+	X402Version int             `json:"x402Version"`
+	Scheme      string          `json:"scheme"`
+	Network     string          `json:"network"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// x402Verifier checks and settles payments. Verify returns the payer;
+// errors wrapping errPaymentInvalid are the client's fault.
+type x402Verifier interface {
+	Verify(ctx context.Context, p x402Payment, req x402Requirement) (payer string, err error)
+	Settle(ctx context.Context, p x402Payment, req x402Requirement) (transaction string, err error)
+}
+
+var errPaymentInvalid = errors.New("invalid payment")
+
+type x402Config struct {
+	Network  string
+	Asset    string
+	PayTo    string
+	Timeout  time.Duration
+	verifier x402Verifier
+	issued   *rateLimiter // nonces per client IP and minute
+}
+
+// x402 is nil when X402_PAY_TO is not set.
+var x402 *x402Config
+
+func setupX402() {
+	payTo := os.Getenv("X402_PAY_TO")
+	if payTo == "" {
+		return
+	}
+	cfg := &x402Config{
+		Network: envOr("X402_NETWORK", "ton"),
+		Asset:   envOr("X402_ASSET", "TON"),
+		PayTo:   payTo,
+		Timeout: 5 * time.Minute,
+	}
+	limit, err := strconv.Atoi(envOr("X402_NONCE_LIMIT", "30"))
+	if err != nil || limit <= 0 {
+		log.Printf("X402_NONCE_LIMIT: invalid value, using 30")
+		limit = 30
+	}
+	cfg.issued = newRateLimiter(limit, time.Minute)
+	switch facilitator := envOr("X402_FACILITATOR", "local"); facilitator {
+	case "local":
+		secret := os.Getenv("X402_LOCAL_SECRET")
+		if secret == "" {
+			log.Printf("x402 disabled: the local facilitator needs X402_LOCAL_SECRET")
+			return
+		}
+		cfg.verifier = localFacilitator{secret: []byte(secret)}
+	default:
+		cfg.verifier = &httpFacilitator{url: strings.TrimRight(facilitator, "/"), client: &http.Client{Timeout: 30 * time.Second}}
+	}
+	x402 = cfg
+	log.Printf("x402 payments to %s on %s (%s)", cfg.PayTo, cfg.Network, cfg.Asset)
+}
+
+// x402Priced reports whether the feature can be paid for with x402.
+func x402Priced(f feature) bool {
+	return x402 != nil && f.X402Amount != ""
+}
+
+func (cfg *x402Config) requirement(f feature, n store.X402Nonce) x402Requirement {
+	return x402Requirement{
+		Scheme:            "exact",
+		Network:           cfg.Network,
+		MaxAmountRequired: n.Amount,
+		Resource:          n.Resource,
+		Description:       f.Name,
+		MimeType:          "application/json",
+		PayTo:             cfg.PayTo,
+		MaxTimeoutSeconds: int(cfg.Timeout.Seconds()),
+		Asset:             n.Asset,
+		Extra:             map[string]string{"nonce": n.Nonce},
+	}
+}
+
+func requestURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host + c.Request.URL.Path
+}
+
+// x402Accepts issues a fresh nonce and the requirements that go with it.
+func x402Accepts(c *gin.Context, f feature) ([]x402Requirement, error) {
+	nonce, err := randomToken("", 16)
+	if err != nil {
+		return nil, err
+	}
+	n := store.X402Nonce{
+		Nonce:     nonce,
+		Feature:   f.ID,
+		Resource:  requestURL(c),
+		Amount:    f.X402Amount,
+		Asset:     x402.Asset,
+		ExpiresAt: time.Now().Add(x402.Timeout),
+	}
+	if err := db.CreateX402Nonce(&n); err != nil {
+		return nil, err
+	}
+	return []x402Requirement{x402.requirement(f, n)}, nil
+}
+
+// abortPaymentRequired answers 402 with the unlock options and, for x402
+// priced features, fresh payment requirements. reason replaces the error.
+func abortPaymentRequired(c *gin.Context, f feature, reason string) {
+	body := paymentRequired(f)
+	if x402Priced(f) {
+		// Every 402 stores a nonce; cap how fast one client can make us
+		if !x402.issued.Allow(c.ClientIP()) {
+			c.Header("Retry-After", "60")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many payment requests, try again in a minute"})
+			return
+		}
+		accepts, err := x402Accepts(c, f)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		body["x402Version"] = x402Version
+		body["accepts"] = accepts
+	}
+	if reason != "" {
+		body["error"] = reason
+	}
+	c.AbortWithStatusJSON(http.StatusPaymentRequired, body)
+}
+
+func decodeX402Payment(header string) (x402Payment, string, error) {
+	var p x402Payment
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header))
+	if err != nil {
+		return p, "", err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, "", err
+	}
+	var payload struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := json.Unmarshal(p.Payload, &payload); err != nil || payload.Nonce == "" {
+		return p, "", errors.New("payload has no nonce")
+	}
+	return p, payload.Nonce, nil
+}
+
+// admitX402 verifies the X-PAYMENT header and runs the handler with the
+// payer as the user. The answer is held back: only a successful one settles
+// the payment and goes out with the receipt, a failed one releases the
+// nonce so the same payment can be retried.
+func admitX402(c *gin.Context, f feature) {
+	p, nonce, err := decodeX402Payment(c.GetHeader(x402Header))
+	if err != nil {
+		abortPaymentRequired(c, f, "Invalid X-PAYMENT header: "+err.Error())
+		return
+	}
+	n, err := db.GetX402Nonce(nonce)
+	if errors.Is(err, store.ErrNotFound) || err == nil && (n.Used || n.Feature != f.ID || time.Now().After(n.ExpiresAt)) {
+		abortPaymentRequired(c, f, "Unknown, used or expired payment nonce")
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	req := x402.requirement(f, n)
+	if p.X402Version != x402Version || p.Scheme != req.Scheme || p.Network != req.Network {
+		abortPaymentRequired(c, f, "Unsupported payment scheme or network")
+		return
+	}
+
+	ctx := c.Request.Context()
+	payer, err := x402.verifier.Verify(ctx, p, req)
+	if errors.Is(err, errPaymentInvalid) {
+		abortPaymentRequired(c, f, "Payment rejected: "+err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("x402 verify: %v", err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "Payment facilitator unavailable"})
+		return
+	}
+	// Claim the nonce for the duration of the call so a concurrent request
+	// cannot spend the same payment
+	if ok, err := db.UseX402Nonce(nonce); err != nil || !ok {
+		abortPaymentRequired(c, f, "Unknown, used or expired payment nonce")
+		return
+	}
+
+	c.Set(entitlementKey, featureAccess{Feature: f.ID, Via: "x402"})
+	setCallUser(c, "x402:"+payer)
+	w := &heldResponse{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	if w.Status() >= http.StatusBadRequest || c.GetBool(streamFailedKey) {
+		if err := db.ReleaseX402Nonce(nonce); err != nil {
+			log.Printf("x402 release: %v", err)
+		}
+		w.flush()
+		return
+	}
+	tx, err := x402.verifier.Settle(ctx, p, req)
+	if err != nil {
+		log.Printf("x402 settle: %v", err)
+		c.Header("Content-Type", "")
+		abortPaymentRequired(c, f, "Payment settlement failed")
+		return
+	}
+
+	receipt := store.X402Receipt{
+		Nonce:       nonce,
+		Feature:     f.ID,
+		Resource:    req.Resource,
+		Payer:       payer,
+		Amount:      req.MaxAmountRequired,
+		Asset:       req.Asset,
+		Network:     req.Network,
+		Transaction: tx,
+	}
+	if receipt.ID, err = randomToken("rcpt_", 16); err == nil {
+		err = db.SaveX402Receipt(&receipt)
+	}
+	if err != nil {
+		log.Printf("x402 receipt: %v", err)
+	}
+	header, _ := json.Marshal(gin.H{
+		"success":     true,
+		"transaction": tx,
+		"network":     req.Network,
+		"payer":       payer,
+		"receipt":     receipt.ID,
+	})
+	c.Header(x402ResponseHeader, base64.StdEncoding.EncodeToString(header))
+	w.flush()
+}
+
+// heldResponse buffers a handler's answer, streamed ones included, until
+// flush sends it to the client.
+type heldResponse struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *heldResponse) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *heldResponse) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *heldResponse) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *heldResponse) WriteString(s string) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.WriteString(s)
+}
+
+func (w *heldResponse) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *heldResponse) Size() int {
+	if w.status == 0 {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *heldResponse) Written() bool {
+	return w.status != 0
+}
+
+// Flush is a no-op: nothing reaches the client before the payment settles.
+func (w *heldResponse) Flush() {}
+
+func (w *heldResponse) flush() {
+	w.ResponseWriter.WriteHeader(w.Status())
+	w.ResponseWriter.WriteHeaderNow()
+	if w.body.Len() > 0 {
+		if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+			log.Printf("x402 response: %v", err)
+		}
+	}
+}
+
+func handleX402Receipt(c *gin.Context) {
+	r, err := db.GetX402Receipt(c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// localFacilitator is an in-process stand-in for a facilitator. A payment is
+// valid when it is signed with the shared X402_LOCAL_SECRET, which plays the
+// part of the payer's wallet signature; cmd/x402pay signs such payments.
+type localFacilitator struct {
+	secret []byte
+}
+
+// localPayment is the payload of the local "exact" scheme.
+type localPayment struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Amount      string `json:"amount"`
+	Asset       string `json:"asset"`
+	Nonce       string `json:"nonce"`
+	ValidBefore int64  `json:"validBefore"`
+	Signature   string `json:"signature"`
+}
+
+func (f localFacilitator) sign(p localPayment) string {
+	mac := hmac.New(sha256.New, f.secret)
+	fmt.Fprintf(mac, "%s|%s|%s|%s|%s|%d", p.From, p.To, p.Amount, p.Asset, p.Nonce, p.ValidBefore)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f localFacilitator) Verify(ctx context.Context, p x402Payment, req x402Requirement) (string, error) {
+	var lp localPayment
+	if err := json.Unmarshal(p.Payload, &lp); err != nil {
+		return "", fmt.Errorf("%w: %v", errPaymentInvalid, err)
+	}
+	paid, ok1 := new(big.Int).SetString(lp.Amount, 10)
+	want, ok2 := new(big.Int).SetString(req.MaxAmountRequired, 10)
+	switch {
+	case !hmac.Equal([]byte(lp.Signature), []byte(f.sign(lp))):
+		return "", fmt.Errorf("%w: bad signature", errPaymentInvalid)
+	case lp.To != req.PayTo || lp.Asset != req.Asset:
+		return "", fmt.Errorf("%w: wrong recipient or asset", errPaymentInvalid)
+	case !ok1 || !ok2 || paid.Cmp(want) < 0:
+		return "", fmt.Errorf("%w: amount below %s", errPaymentInvalid, req.MaxAmountRequired)
+	case lp.Nonce != req.Extra["nonce"]:
+		return "", fmt.Errorf("%w: nonce mismatch", errPaymentInvalid)
+	case time.Now().Unix() >= lp.ValidBefore:
+		return "", fmt.Errorf("%w: authorization expired", errPaymentInvalid)
+	}
+	return lp.From, nil
+}
+
+func (f localFacilitator) Settle(ctx context.Context, p x402Payment, req x402Requirement) (string, error) {
+	sum := sha256.Sum256(p.Payload)
+	return "local:" + hex.EncodeToString(sum[:16]), nil
+}
+
+// httpFacilitator talks to a facilitator's /verify and /settle endpoints.
+type httpFacilitator struct {
+	url    string
+	client *http.Client
+}
+
+func (f *httpFacilitator) post(ctx context.Context, path string, p x402Payment, req x402Requirement, out interface{}) error {
+	body, _ := json.Marshal(gin.H{"x402Version": x402Version, "paymentPayload": p, "paymentRequirements": req})
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := f.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("facilitator %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (f *httpFacilitator) Verify(ctx context.Context, p x402Payment, req x402Requirement) (string, error) {
+	var res struct {
+		IsValid       bool   `json:"isValid"`
+		InvalidReason string `json:"invalidReason"`
+		Payer         string `json:"payer"`
+	}
+	if err := f.post(ctx, "/verify", p, req, &res); err != nil {
+		return "", err
+	}
+	if !res.IsValid {
+		return "", fmt.Errorf("%w: %s", errPaymentInvalid, res.InvalidReason)
+	}
+	return res.Payer, nil
+}
+
+func (f *httpFacilitator) Settle(ctx context.Context, p x402Payment, req x402Requirement) (string, error) {
+	var res struct {
+		Success     bool   `json:"success"`
+		ErrorReason string `json:"errorReason"`
+		Transaction string `json:"transaction"`
+	}
+	if err := f.post(ctx, "/settle", p, req, &res); err != nil {
+		return "", err
+	}
+	if !res.Success {
+		return "", errors.New("settlement failed: " + res.ErrorReason)
+	}
+	return res.Transaction, nil
+}
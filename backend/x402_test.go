@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingVerifier is the local facilitator with settlements counted and
+// optionally failing.
+type countingVerifier struct {
+	localFacilitator
+	settled    int
+	failSettle bool
+}
+
+func (v *countingVerifier) Settle(ctx context.Context, p x402Payment, req x402Requirement) (string, error) {
+	if v.failSettle {
+		return "", errors.New("chain congested")
+	}
+	v.settled++
+	return v.localFacilitator.Settle(ctx, p, req)
+}
+
+// x402Router serves the x402-priced startup-builder feature; the handler
+// answers with the status in ?status=.
+func x402Router(t *testing.T) (*gin.Engine, *countingVerifier) {
+	t.Helper()
+	useTestDB(t)
+	v := &countingVerifier{localFacilitator: localFacilitator{secret: []byte("test-secret")}}
+	prev := x402
+	x402 = &x402Config{Network: "ton", Asset: "TON", PayTo: "shop", Timeout: time.Minute, verifier: v,
+		issued: newRateLimiter(3, time.Minute)}
+	t.Cleanup(func() { x402 = prev })
+
+	r := gin.New()
+	r.POST("/paid", requireFeature("startup-builder"), func(c *gin.Context) {
+		status := http.StatusOK
+		if c.Query("status") == "500" {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"ok": status == http.StatusOK})
+	})
+	return r, v
+}
+
+func x402Call(r *gin.Engine, query, payment string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/paid"+query, nil)
+	if payment != "" {
+		req.Header.Set(x402Header, payment)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// x402Pay answers the 402 in w with a payment signed by v.
+func x402Pay(t *testing.T, v *countingVerifier, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var challenge struct {
+		Accepts []x402Requirement `json:"accepts"`
+	}
+	if w.Code != http.StatusPaymentRequired || json.Unmarshal(w.Body.Bytes(), &challenge) != nil || len(challenge.Accepts) == 0 {
+		t.Fatalf("want a 402 with requirements, got %d %s", w.Code, w.Body)
+	}
+	req := challenge.Accepts[0]
+	lp := localPayment{From: "agent", To: req.PayTo, Amount: req.MaxAmountRequired, Asset: req.Asset,
+		Nonce: req.Extra["nonce"], ValidBefore: time.Now().Add(time.Minute).Unix()}
+	lp.Signature = v.sign(lp)
+	payload, _ := json.Marshal(lp)
+	data, _ := json.Marshal(x402Payment{X402Version: x402Version, Scheme: req.Scheme, Network: req.Network, Payload: payload})
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestX402SettlesAfterSuccess(t *testing.T) {
+	r, v := x402Router(t)
+	payment := x402Pay(t, v, x402Call(r, "", ""))
+
+	w := x402Call(r, "", payment)
+	if w.Code != http.StatusOK || w.Header().Get(x402ResponseHeader) == "" {
+		t.Fatalf("paid call: %d %s, receipt %q", w.Code, w.Body, w.Header().Get(x402ResponseHeader))
+	}
+	if v.settled != 1 {
+		t.Errorf("settled %d times, want 1", v.settled)
+	}
+	if w := x402Call(r, "", payment); w.Code != http.StatusPaymentRequired {
+		t.Errorf("replayed payment: %d, want 402", w.Code)
+	}
+	if v.settled != 1 {
+		t.Errorf("replay settled again")
+	}
+}
+
+func TestX402FailedCallIsNotSettled(t *testing.T) {
+	r, v := x402Router(t)
+	payment := x402Pay(t, v, x402Call(r, "", ""))
+
+	w := x402Call(r, "?status=500", payment)
+	if w.Code != http.StatusInternalServerError || w.Header().Get(x402ResponseHeader) != "" {
+		t.Fatalf("failed call: %d, receipt %q", w.Code, w.Header().Get(x402ResponseHeader))
+	}
+	if v.settled != 0 {
+		t.Fatalf("failed call settled the payment")
+	}
+	// The unsettled payment may be retried
+	if w := x402Call(r, "", payment); w.Code != http.StatusOK || v.settled != 1 {
+		t.Errorf("retry: %d, settled %d", w.Code, v.settled)
+	}
+}
+
+func TestX402SettlementFailure(t *testing.T) {
+	r, v := x402Router(t)
+	payment := x402Pay(t, v, x402Call(r, "", ""))
+	v.failSettle = true
+
+	w := x402Call(r, "", payment)
+	if w.Code != http.StatusPaymentRequired || w.Header().Get(x402ResponseHeader) != "" {
+		t.Fatalf("unsettled call: %d %s", w.Code, w.Body)
+	}
+	var body struct {
+		Error string `json:"error"`
+		OK    bool   `json:"ok"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.OK || body.Error == "" {
+		t.Errorf("unsettled call leaked the answer: %s", w.Body)
+	}
+}
+
+func TestX402NonceLimit(t *testing.T) {
+	r, _ := x402Router(t)
+	for i := 0; i < 3; i++ {
+		if w := x402Call(r, "", ""); w.Code != http.StatusPaymentRequired {
+			t.Fatalf("call %d: %d, want 402", i, w.Code)
+		}
+	}
+	if w := x402Call(r, "", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("call over the limit: %d, want 429", w.Code)
+	}
+}